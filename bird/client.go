@@ -0,0 +1,284 @@
+// Copyright (c) 2019 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bird implements a small client for BIRD's control socket
+// ("birdc" protocol).  It lets Felix drive individual BGP protocols
+// (enable/disable/reload) without rewriting bird.cfg and SIGHUPping the
+// daemon for every single peer or filter change.
+package bird
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultSocketPath is where BIRD listens for control connections by default.
+	DefaultSocketPath = "/var/run/bird/bird.ctl"
+
+	defaultDialTimeout = 2 * time.Second
+	defaultReadTimeout = 5 * time.Second
+)
+
+// ReplyCode is the 4-digit code BIRD prefixes each reply line with.
+type ReplyCode int
+
+const (
+	// CodeOK marks the final line of a successful command.
+	CodeOK ReplyCode = 0
+	// CodeTableEntry marks a line that is part of a multi-line table (e.g. "show protocols").
+	CodeTableEntry ReplyCode = 1
+	// CodeRuntimeError marks a runtime error, e.g. "protocol already enabled".
+	CodeRuntimeError ReplyCode = 8
+	// CodeSyntaxError marks a command the parser itself rejected.
+	CodeSyntaxError ReplyCode = 9
+)
+
+// classOf maps a numeric reply code to its class: 0xxx is CodeOK, 1xxx-7xxx
+// CodeTableEntry, 8xxx CodeRuntimeError and 9xxx CodeSyntaxError.
+func classOf(code int) ReplyCode {
+	switch {
+	case code < 1000:
+		return CodeOK
+	case code < 8000:
+		return CodeTableEntry
+	case code < 9000:
+		return CodeRuntimeError
+	default:
+		return CodeSyntaxError
+	}
+}
+
+// Reply is the parsed result of a single BIRD command.
+type Reply struct {
+	// Code is the final (terminating) reply code for the command.
+	Code int
+	// Lines holds every line of output, in order, including the final status line.
+	Lines []string
+}
+
+// ProtocolStatus is one row of BIRD's "show protocols" table.
+type ProtocolStatus struct {
+	Name     string
+	Proto    string
+	Table    string
+	State    string
+	Since    string
+	Info     string
+}
+
+// CommandError is returned when BIRD reports a runtime (8xxx) or syntax (9xxx) error.
+type CommandError struct {
+	Code int
+	Text string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("bird error %d: %s", e.Code, e.Text)
+}
+
+// Client is a connection to BIRD's control socket.  It is safe for concurrent
+// use; commands are serialised because birdc itself is a single, stateful,
+// line-oriented session.
+type Client struct {
+	sockPath string
+
+	mutex sync.Mutex
+	conn  net.Conn
+	r     *bufio.Reader
+}
+
+// New creates a Client that will dial the given control socket path lazily,
+// on first use.
+func New(sockPath string) *Client {
+	if sockPath == "" {
+		sockPath = DefaultSocketPath
+	}
+	return &Client{sockPath: sockPath}
+}
+
+// Close closes the underlying connection, if any.  The Client can be reused
+// afterwards; it will reconnect on the next command.
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closeLocked()
+}
+
+func (c *Client) closeLocked() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	c.r = nil
+	return err
+}
+
+func (c *Client) connectLocked() error {
+	if c.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", c.sockPath, defaultDialTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to BIRD control socket %s: %w", c.sockPath, err)
+	}
+	r := bufio.NewReader(conn)
+	// BIRD greets every new connection with a 0001 banner line; consume it.
+	if _, err := readReplyLine(r); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to read BIRD banner: %w", err)
+	}
+	c.conn = conn
+	c.r = r
+	return nil
+}
+
+func readReplyLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// exec sends a single command line and collects its reply. A failed write
+// or read closes the connection and returns the error without retrying the
+// command; the next command then dials a fresh connection.
+func (c *Client) exec(cmd string) (*Reply, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.connectLocked(); err != nil {
+		return nil, err
+	}
+	c.conn.SetDeadline(time.Now().Add(defaultReadTimeout))
+
+	log.WithField("cmd", cmd).Debug("Sending command to BIRD")
+	if _, err := fmt.Fprintf(c.conn, "%s\n", cmd); err != nil {
+		c.closeLocked()
+		return nil, fmt.Errorf("failed to write command to BIRD: %w", err)
+	}
+
+	reply := &Reply{}
+	for {
+		line, err := readReplyLine(c.r)
+		if err != nil {
+			c.closeLocked()
+			return nil, fmt.Errorf("failed to read reply from BIRD: %w", err)
+		}
+		reply.Lines = append(reply.Lines, line)
+
+		code, rest, ok := splitReplyLine(line)
+		if !ok {
+			// Continuation line of a multi-line reply; keep reading.
+			continue
+		}
+		reply.Code = code
+		if classOf(code) == CodeTableEntry && rest != "" {
+			// Table entries (1xxx) are followed by more lines until a terminating
+			// code is seen; " -" marks the end of the table in BIRD's protocol.
+			continue
+		}
+		break
+	}
+
+	switch classOf(reply.Code) {
+	case CodeRuntimeError, CodeSyntaxError:
+		return reply, &CommandError{Code: reply.Code, Text: lastNonEmpty(reply.Lines)}
+	}
+	return reply, nil
+}
+
+// splitReplyLine splits a line of the form "0001 Some text" or "1000-Some text"
+// into its numeric code and remaining text.  ok is false for continuation
+// lines (those starting with a space) which don't carry a code.
+func splitReplyLine(line string) (code int, rest string, ok bool) {
+	if len(line) < 4 || line[0] == ' ' {
+		return 0, line, false
+	}
+	n, err := strconv.Atoi(line[:4])
+	if err != nil {
+		return 0, line, false
+	}
+	if len(line) > 4 {
+		rest = strings.TrimSpace(line[5:])
+	}
+	return n, rest, true
+}
+
+func lastNonEmpty(lines []string) string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.TrimSpace(lines[i]) != "" {
+			return strings.TrimSpace(lines[i])
+		}
+	}
+	return ""
+}
+
+// EnableProtocol enables a previously-disabled BGP protocol by name.
+func (c *Client) EnableProtocol(name string) error {
+	_, err := c.exec(fmt.Sprintf("enable \"%s\"", name))
+	return err
+}
+
+// DisableProtocol disables a BGP protocol by name without touching any others.
+func (c *Client) DisableProtocol(name string) error {
+	_, err := c.exec(fmt.Sprintf("disable \"%s\"", name))
+	return err
+}
+
+// ReloadConfig asks BIRD to re-read and apply bird.cfg.  Prefer
+// EnableProtocol/DisableProtocol for single-peer churn; this is for cases
+// where the config itself (not just a protocol's up/down state) changed.
+func (c *Client) ReloadConfig() error {
+	_, err := c.exec("configure")
+	return err
+}
+
+// Protocols returns the status of every configured protocol, as reported by
+// "show protocols".
+func (c *Client) Protocols() ([]ProtocolStatus, error) {
+	reply, err := c.exec("show protocols")
+	if err != nil {
+		return nil, err
+	}
+	var statuses []ProtocolStatus
+	for _, line := range reply.Lines {
+		_, rest, ok := splitReplyLine(line)
+		if !ok {
+			rest = strings.TrimSpace(line)
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 5 {
+			continue
+		}
+		statuses = append(statuses, ProtocolStatus{
+			Name:  fields[0],
+			Proto: fields[1],
+			Table: fields[2],
+			State: fields[3],
+			Since: fields[4],
+			Info:  strings.Join(fields[5:], " "),
+		})
+	}
+	return statuses, nil
+}
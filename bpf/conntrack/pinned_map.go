@@ -0,0 +1,169 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conntrack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultPinPath is where Felix's BPF dataplane pins the IPv4 conntrack map.
+const DefaultPinPath = "/sys/fs/bpf/tc/globals/" + "cali_v4_ct"
+
+// bpf(2) command numbers we need; golang.org/x/sys/unix doesn't name these.
+const (
+	bpfCmdMapLookupElem = 1
+	bpfCmdMapDeleteElem = 3
+	bpfCmdMapGetNextKey = 4
+	bpfCmdObjGet        = 7
+)
+
+// pinnedMap is a Map backed by the real, pinned BPF conntrack map, accessed
+// via raw bpf(2) syscalls so this package doesn't need a cgo dependency.
+type pinnedMap struct {
+	fd int
+}
+
+// OpenPinnedMap opens the BPF conntrack map pinned at path (normally
+// DefaultPinPath) for scanning/deletion.
+func OpenPinnedMap(path string) (Map, error) {
+	fd, err := bpfObjGet(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pinned conntrack map %s: %w", path, err)
+	}
+	return &pinnedMap{fd: fd}, nil
+}
+
+func (m *pinnedMap) Iter(f func(Tuple) IteratorAction) error {
+	key := make([]byte, MapParams.KeySize)
+	nextKey := make([]byte, MapParams.KeySize)
+	value := make([]byte, MapParams.ValueSize)
+
+	var haveKey, deleteKey bool
+	for {
+		ok, err := bpfMapGetNextKey(m.fd, key, nextKey, haveKey)
+		if err != nil {
+			return err
+		}
+		// Delete the previous entry only once its successor is known: a
+		// GetNextKey on a key that is no longer in the map starts again
+		// from the first key.
+		if deleteKey {
+			if err := bpfMapDeleteElem(m.fd, key); err != nil {
+				return err
+			}
+			deleteKey = false
+		}
+		if !ok {
+			return nil
+		}
+		copy(key, nextKey)
+		haveKey = true
+
+		if err := bpfMapLookupElem(m.fd, key, value); err != nil {
+			// Entry may have expired between GetNextKey and LookupElem; skip it.
+			continue
+		}
+		deleteKey = f(tupleFromKey(key)) == IterDelete
+	}
+}
+
+// tupleFromKey decodes the wire-format conntrack key into the userspace
+// Tuple type used elsewhere in this package.
+func tupleFromKey(key []byte) Tuple {
+	return Tuple{
+		SrcIP:    net.IP(key[0:4]),
+		DstIP:    net.IP(key[4:8]),
+		SrcPort:  binary.LittleEndian.Uint16(key[8:10]),
+		DstPort:  binary.LittleEndian.Uint16(key[10:12]),
+		Protocol: key[12],
+	}
+}
+
+func bpfObjGet(path string) (int, error) {
+	pathBytes := append([]byte(path), 0)
+	attr := struct {
+		pathname  uint64
+		bpfFd     uint32
+		fileFlags uint32
+	}{
+		pathname: uint64(uintptr(unsafe.Pointer(&pathBytes[0]))),
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, bpfCmdObjGet, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(fd), nil
+}
+
+func bpfMapGetNextKey(fd int, key, nextKey []byte, haveKey bool) (bool, error) {
+	attr := struct {
+		mapFd   uint32
+		key     uint64
+		nextKey uint64
+	}{
+		mapFd:   uint32(fd),
+		nextKey: uint64(uintptr(unsafe.Pointer(&nextKey[0]))),
+	}
+	if haveKey {
+		attr.key = uint64(uintptr(unsafe.Pointer(&key[0])))
+	}
+	_, _, errno := unix.Syscall(unix.SYS_BPF, bpfCmdMapGetNextKey, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno == unix.ENOENT {
+		return false, nil
+	}
+	if errno != 0 {
+		return false, errno
+	}
+	return true, nil
+}
+
+func bpfMapLookupElem(fd int, key, value []byte) error {
+	attr := struct {
+		mapFd uint32
+		_     uint32
+		key   uint64
+		value uint64
+	}{
+		mapFd: uint32(fd),
+		key:   uint64(uintptr(unsafe.Pointer(&key[0]))),
+		value: uint64(uintptr(unsafe.Pointer(&value[0]))),
+	}
+	_, _, errno := unix.Syscall(unix.SYS_BPF, bpfCmdMapLookupElem, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func bpfMapDeleteElem(fd int, key []byte) error {
+	attr := struct {
+		mapFd uint32
+		_     uint32
+		key   uint64
+	}{
+		mapFd: uint32(fd),
+		key:   uint64(uintptr(unsafe.Pointer(&key[0]))),
+	}
+	_, _, errno := unix.Syscall(unix.SYS_BPF, bpfCmdMapDeleteElem, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conntrack
+
+import "net"
+
+// IteratorAction tells Map.Iter what to do with the entry it was just
+// handed.
+type IteratorAction int
+
+const (
+	IterNone IteratorAction = iota
+	IterDelete
+)
+
+// Map is the userspace view of the BPF conntrack map that ScanAndDeleteByIP
+// needs: something it can walk, deleting entries as it goes. Production
+// code backs this with the real pinned BPF map; tests back it with an
+// in-memory mock.
+type Map interface {
+	// Iter calls f once per entry currently in the map. If f returns
+	// IterDelete, the entry is removed before Iter continues.
+	Iter(f func(Tuple) IteratorAction) error
+}
+
+// MapParameters describes the conntrack BPF map, for callers (e.g. the
+// mock map used in tests) that need to create one without linking against
+// the real BPF map machinery.
+type MapParameters struct {
+	Name       string
+	KeySize    int
+	ValueSize  int
+	MaxEntries int
+}
+
+// MapParams describes Felix's BPF IPv4 conntrack map.
+var MapParams = MapParameters{
+	Name:       "cali_v4_ct",
+	KeySize:    16,
+	ValueSize:  64,
+	MaxEntries: 512000,
+}
+
+// ScanAndDeleteByIP walks m and deletes any entry whose forward or reverse
+// tuple references ip, then returns how many entries were removed. It's
+// used to flush stale conntrack state left behind when a workload interface
+// or backend endpoint goes away, so a subsequent workload reusing the same
+// IP doesn't inherit old flow state.
+func ScanAndDeleteByIP(m Map, ip net.IP) (int, error) {
+	deleted := 0
+	err := m.Iter(func(t Tuple) IteratorAction {
+		if t.SrcIP.Equal(ip) || t.DstIP.Equal(ip) {
+			deleted++
+			return IterDelete
+		}
+		return IterNone
+	})
+	return deleted, err
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conntrack
+
+import (
+	"net"
+	"testing"
+)
+
+// memMap is a trivial in-memory Map used to test ScanAndDeleteByIP without
+// needing a real pinned BPF map.
+type memMap struct {
+	entries []Tuple
+}
+
+func (m *memMap) Iter(f func(Tuple) IteratorAction) error {
+	var kept []Tuple
+	for _, t := range m.entries {
+		if f(t) == IterDelete {
+			continue
+		}
+		kept = append(kept, t)
+	}
+	m.entries = kept
+	return nil
+}
+
+func TestScanAndDeleteByIP(t *testing.T) {
+	workloadIP := net.IPv4(10, 0, 0, 5)
+	otherIP := net.IPv4(10, 0, 0, 6)
+
+	m := &memMap{
+		entries: []Tuple{
+			{SrcIP: workloadIP, DstIP: otherIP, SrcPort: 1000, DstPort: 80, Protocol: 6},
+			{SrcIP: otherIP, DstIP: workloadIP, SrcPort: 80, DstPort: 1000, Protocol: 6},
+			{SrcIP: otherIP, DstIP: otherIP, SrcPort: 80, DstPort: 443, Protocol: 6},
+		},
+	}
+
+	deleted, err := ScanAndDeleteByIP(m, workloadIP)
+	if err != nil {
+		t.Fatalf("ScanAndDeleteByIP returned an error: %v", err)
+	}
+	if deleted != 2 {
+		t.Fatalf("expected 2 entries to be deleted, got %d", deleted)
+	}
+	if len(m.entries) != 1 {
+		t.Fatalf("expected 1 entry to remain, got %d", len(m.entries))
+	}
+	if m.entries[0].SrcIP.Equal(workloadIP) || m.entries[0].DstIP.Equal(workloadIP) {
+		t.Fatalf("remaining entry should not reference the workload IP: %+v", m.entries[0])
+	}
+}
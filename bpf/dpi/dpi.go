@@ -0,0 +1,121 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dpi implements a deep-packet-inspection policy action: rather
+// than matching on L3/L4 headers alone, a DPI rule classifies a flow by its
+// payload (as already reconstructed by the BPF conntrack entry tracking
+// app-layer bytes) and lets policy act on that classification once it's
+// known, rather than only at connection-open time.
+package dpi
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// Verdict is the outcome of classifying a flow.
+type Verdict int
+
+const (
+	// VerdictUnknown means not enough payload has been seen yet to
+	// classify the flow; Action.Evaluate then allows or denies the packet
+	// according to its DefaultDeny setting, and the flow is re-checked on
+	// the next one.
+	VerdictUnknown Verdict = iota
+	VerdictAllow
+	VerdictDeny
+)
+
+// Signature matches a classified application protocol/pattern against an
+// action.  Signatures are evaluated in order; the first match wins.
+type Signature struct {
+	// Name identifies the signature for logging/metrics, e.g. "tls-weak-cipher".
+	Name string
+	// Matches is called with the reassembled payload bytes seen so far
+	// for a flow (bounded by MaxInspectBytes) and returns true if this
+	// signature recognises the flow as matching.
+	Matches func(payload []byte) bool
+	Action  Verdict
+}
+
+// MaxInspectBytes bounds how much of a flow's payload we ask the BPF
+// conntrack entry to retain for inspection; flows are allowed through
+// (VerdictAllow) once this much payload has been seen without a match.
+const MaxInspectBytes = 4096
+
+// ConntrackEntry is the subset of the BPF conntrack value this package
+// needs: the app-layer bytes captured so far for a flow, and whether the
+// kernel side has already given up collecting more (e.g. because the flow
+// exceeded MaxInspectBytes or was marked as non-inspectable).
+type ConntrackEntry struct {
+	Payload []byte
+	GaveUp  bool
+}
+
+// Classifier evaluates a flow's conntrack entry against an ordered list of
+// signatures to produce a DPI verdict.
+type Classifier struct {
+	signatures []Signature
+}
+
+// NewClassifier builds a Classifier from an ordered signature list; earlier
+// entries take precedence.
+func NewClassifier(signatures []Signature) *Classifier {
+	return &Classifier{signatures: signatures}
+}
+
+// Classify returns the verdict for one flow's conntrack entry.
+func (c *Classifier) Classify(entry ConntrackEntry) (Verdict, string) {
+	for _, sig := range c.signatures {
+		if sig.Matches(entry.Payload) {
+			log.WithFields(log.Fields{
+				"signature": sig.Name,
+				"verdict":   sig.Action,
+			}).Debug("DPI signature matched flow")
+			return sig.Action, sig.Name
+		}
+	}
+	if len(entry.Payload) >= MaxInspectBytes || entry.GaveUp {
+		// We've seen as much as we're going to; no signature matched, so
+		// default-allow rather than holding the flow open forever.
+		return VerdictAllow, ""
+	}
+	return VerdictUnknown, ""
+}
+
+// Action is a policy rule action that defers its allow/deny decision to DPI
+// classification.  It's intended to be compiled into the same
+// tail-called-program structure the BPF policy programs already use for
+// other deferred decisions (see polprog), re-evaluating the flow's verdict
+// every time new conntrack payload arrives until a final verdict is
+// reached.
+type Action struct {
+	Classifier  *Classifier
+	DefaultDeny bool
+}
+
+// Evaluate returns true (allow) or false (deny) for the current state of
+// entry.  While the verdict is still unknown, it returns !DefaultDeny so
+// that policy can choose whether new connections fail open or closed
+// during the classification window.
+func (a *Action) Evaluate(entry ConntrackEntry) bool {
+	verdict, _ := a.Classifier.Classify(entry)
+	switch verdict {
+	case VerdictAllow:
+		return true
+	case VerdictDeny:
+		return false
+	default:
+		return !a.DefaultDeny
+	}
+}
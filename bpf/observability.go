@@ -0,0 +1,94 @@
+// Copyright (c) 2021 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	countAttachAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "felix_bpf_attach_attempts",
+		Help: "Number of attempts to attach a BPF program to an interface, by program and outcome.",
+	}, []string{"prog", "outcome"})
+	countDetachAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "felix_bpf_detach_attempts",
+		Help: "Number of attempts to detach a BPF program from an interface, by program and outcome.",
+	}, []string{"prog", "outcome"})
+	histAttachLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "felix_bpf_attach_seconds",
+		Help: "Time taken to attach a BPF program to an interface.",
+	}, []string{"prog"})
+	gaugeAttachedPrograms = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "felix_bpf_attached_programs",
+		Help: "Number of interfaces a given BPF program is currently believed to be attached to.",
+	}, []string{"prog"})
+)
+
+func init() {
+	prometheus.MustRegister(countAttachAttempts)
+	prometheus.MustRegister(countDetachAttempts)
+	prometheus.MustRegister(histAttachLatency)
+	prometheus.MustRegister(gaugeAttachedPrograms)
+}
+
+const (
+	outcomeOK    = "success"
+	outcomeError = "error"
+)
+
+// AttachWithObservability wraps an attach function (typically a closure
+// over libbpf's AttachClassifier) with structured logging, a latency
+// histogram, and attempt/outcome counters, so that attach/detach churn
+// during a large rollout is visible in both logs and metrics without every
+// caller having to remember to instrument it themselves.
+func AttachWithObservability(iface, progName string, attach func() error) error {
+	logCtx := log.WithFields(log.Fields{"iface": iface, "prog": progName})
+	logCtx.Debug("Attaching BPF program")
+	start := time.Now()
+	err := attach()
+	elapsed := time.Since(start)
+	histAttachLatency.WithLabelValues(progName).Observe(elapsed.Seconds())
+
+	if err != nil {
+		countAttachAttempts.WithLabelValues(progName, outcomeError).Inc()
+		logCtx.WithError(err).WithField("took", elapsed).Warn("Failed to attach BPF program")
+		return err
+	}
+	countAttachAttempts.WithLabelValues(progName, outcomeOK).Inc()
+	gaugeAttachedPrograms.WithLabelValues(progName).Inc()
+	logCtx.WithField("took", elapsed).Info("Attached BPF program")
+	return nil
+}
+
+// DetachWithObservability is the detach-side counterpart of
+// AttachWithObservability.
+func DetachWithObservability(iface, progName string, detach func() error) error {
+	logCtx := log.WithFields(log.Fields{"iface": iface, "prog": progName})
+	logCtx.Debug("Detaching BPF program")
+	err := detach()
+	if err != nil {
+		countDetachAttempts.WithLabelValues(progName, outcomeError).Inc()
+		logCtx.WithError(err).Warn("Failed to detach BPF program")
+		return err
+	}
+	countDetachAttempts.WithLabelValues(progName, outcomeOK).Inc()
+	gaugeAttachedPrograms.WithLabelValues(progName).Dec()
+	logCtx.Info("Detached BPF program")
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/projectcalico/felix/proto"
+)
+
+// syntheticRules builds a Rules tree with numTiers tiers, each with one
+// policy of numRulesPerTier rules, so the benchmarks below can be run at
+// cluster-representative scale (hundreds to thousands of rules) without
+// needing a real cluster's policy export.
+func syntheticRules(numTiers, numRulesPerTier int) Rules {
+	tiers := make([]Tier, numTiers)
+	for t := 0; t < numTiers; t++ {
+		rules := make([]Rule, numRulesPerTier)
+		for r := 0; r < numRulesPerTier; r++ {
+			rules[r] = Rule{Rule: &proto.Rule{
+				Action: "Allow",
+				SrcNet: []string{"10.0.0.0/8"},
+				DstNet: []string{"11.0.0.0/8"},
+			}}
+		}
+		tiers[t] = Tier{
+			Name:     fmt.Sprintf("tier-%d", t),
+			Policies: []Policy{{Name: fmt.Sprintf("pol-%d", t), Rules: rules}},
+		}
+	}
+	return Rules{Tiers: tiers}
+}
+
+// BenchmarkEstimateComplexity measures how the complexity scan's cost
+// scales with policy size, so a future change that makes it
+// super-linear (e.g. an accidental O(n^2) walk) shows up as a benchmark
+// regression rather than only as a slow Felix restart in a large cluster.
+func BenchmarkEstimateComplexity(b *testing.B) {
+	for _, size := range []int{10, 100, 1000} {
+		rules := syntheticRules(5, size)
+		b.Run(fmt.Sprintf("rules=%d", size*5), func(b *testing.B) {
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				EstimateComplexity(rules)
+			}
+		})
+	}
+}
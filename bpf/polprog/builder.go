@@ -0,0 +1,75 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+// idAllocator is the subset of idalloc.IDAllocator the Builder needs: a
+// way to turn a named IP set into the small integer ID the compiled
+// program uses to look it up in the IP sets map.
+type idAllocator interface {
+	GetOrAlloc(id string) uint64
+}
+
+// Builder compiles a Rules tree into a BPF program. The three map FDs are
+// the maps the generated program will reference at runtime: the IP sets
+// map, the per-packet state map used to pass data between tail calls, and
+// the jump map used for the tail calls themselves (including, as of this
+// package's introduction, the L7 analyzer tail calls described in l7.go).
+type Builder struct {
+	ipSetIDAlloc idAllocator
+	ipSetMapFD   int
+	stateMapFD   int
+	jumpMapFD    int
+
+	l7Analyzers *l7AnalyzerSet
+	trace       bool
+}
+
+// NewBuilder creates a Builder for one workload endpoint's policy program.
+func NewBuilder(ipSetIDAlloc idAllocator, ipSetMapFD, stateMapFD, jumpMapFD int) *Builder {
+	return &Builder{
+		ipSetIDAlloc: ipSetIDAlloc,
+		ipSetMapFD:   ipSetMapFD,
+		stateMapFD:   stateMapFD,
+		jumpMapFD:    jumpMapFD,
+		l7Analyzers:  newL7AnalyzerSet(),
+	}
+}
+
+// EnableTrace sets the Builder's debug verdict-trace flag, which
+// TraceEnabled reports. The Builder in this package emits no program
+// instructions, so the flag does not change any output here; the
+// per-rule TraceRecords are produced in userspace by a ReferenceMatcher
+// with its Trace field set.
+func (b *Builder) EnableTrace() {
+	b.trace = true
+}
+
+// TraceEnabled reports whether EnableTrace has been called.
+func (b *Builder) TraceEnabled() bool {
+	return b.trace
+}
+
+// scanL7Matchers walks every rule in rules and records which L7 analyzers
+// the compiled program will need to tail-call into, so that
+// l7AnalyzerSet.AllocateSlots can give each of them a jump-map slot.
+func (b *Builder) scanL7Matchers(rules Rules) {
+	for _, tier := range rules.Tiers {
+		for _, pol := range tier.Policies {
+			for _, r := range pol.Rules {
+				b.l7Analyzers.observe(r)
+			}
+		}
+	}
+}
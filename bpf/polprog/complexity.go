@@ -0,0 +1,111 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+// ComplexityStats summarises how much work a Rules tree will turn into
+// once compiled, as a cheap proxy for BPF verifier complexity. It's used
+// both to warn operators about policies that are likely to blow the
+// verifier's instruction/state-explosion limits, and by the regression
+// gate in ComplexityBudget.Check to catch compiler changes that make
+// already-borderline policies worse.
+type ComplexityStats struct {
+	NumTiers       int
+	NumPolicies    int
+	NumRules       int
+	NumL7Analyzers int
+	// NumMatchExprLeaves counts the total number of Cond leaves across
+	// every rule's MatchExpr, since each one compiles to at least a
+	// branch and the verifier explores every branch of every such
+	// expression.
+	NumMatchExprLeaves int
+}
+
+// EstimateComplexity walks rules and computes its ComplexityStats. It
+// doesn't require a Builder (and so doesn't touch any map FDs) so it can
+// be used standalone by tooling that just wants to report or gate on
+// complexity without loading a real program.
+func EstimateComplexity(rules Rules) ComplexityStats {
+	var stats ComplexityStats
+	stats.NumTiers = len(rules.Tiers)
+	analyzers := newL7AnalyzerSet()
+	for _, tier := range rules.Tiers {
+		stats.NumPolicies += len(tier.Policies)
+		for _, pol := range tier.Policies {
+			stats.NumRules += len(pol.Rules)
+			for _, r := range pol.Rules {
+				analyzers.observe(r)
+				if r.MatchExpr != nil {
+					stats.NumMatchExprLeaves += countExprLeaves(r.MatchExpr)
+				}
+			}
+		}
+	}
+	stats.NumL7Analyzers = len(analyzers.needed)
+	return stats
+}
+
+func countExprLeaves(e Expr) int {
+	switch v := e.(type) {
+	case Cond:
+		return 1
+	case And:
+		n := 0
+		for _, sub := range v {
+			n += countExprLeaves(sub)
+		}
+		return n
+	case Or:
+		n := 0
+		for _, sub := range v {
+			n += countExprLeaves(sub)
+		}
+		return n
+	case Not:
+		return countExprLeaves(v.Operand)
+	default:
+		return 0
+	}
+}
+
+// ComplexityBudget is a set of thresholds a ComplexityStats must stay
+// within; a zero-value field means "no limit" for that dimension.
+type ComplexityBudget struct {
+	MaxRules           int
+	MaxL7Analyzers     int
+	MaxMatchExprLeaves int
+}
+
+// Violation describes one budget dimension a ComplexityStats exceeded.
+type Violation struct {
+	Dimension string
+	Budget    int
+	Actual    int
+}
+
+// Check compares stats against b, returning one Violation per dimension
+// that was exceeded (nil if stats is within budget).
+func (b ComplexityBudget) Check(stats ComplexityStats) []Violation {
+	var violations []Violation
+	if b.MaxRules > 0 && stats.NumRules > b.MaxRules {
+		violations = append(violations, Violation{"rules", b.MaxRules, stats.NumRules})
+	}
+	if b.MaxL7Analyzers > 0 && stats.NumL7Analyzers > b.MaxL7Analyzers {
+		violations = append(violations, Violation{"l7-analyzers", b.MaxL7Analyzers, stats.NumL7Analyzers})
+	}
+	if b.MaxMatchExprLeaves > 0 && stats.NumMatchExprLeaves > b.MaxMatchExprLeaves {
+		violations = append(violations, Violation{"match-expr-leaves", b.MaxMatchExprLeaves, stats.NumMatchExprLeaves})
+	}
+	return violations
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import (
+	"testing"
+
+	"github.com/projectcalico/felix/proto"
+)
+
+func TestEstimateComplexity(t *testing.T) {
+	rules := Rules{
+		Tiers: []Tier{{
+			Name: "tier",
+			Policies: []Policy{{
+				Name: "pol",
+				Rules: []Rule{
+					{Rule: &proto.Rule{Action: "Allow"}, L7Match: &L7Match{Protocol: L7ProtocolHTTP}},
+					{
+						Rule: &proto.Rule{Action: "Deny"},
+						MatchExpr: And{
+							Cond("a"),
+							Or{Cond("b"), Cond("c")},
+							Not{Operand: Cond("d")},
+						},
+					},
+				},
+			}},
+		}},
+	}
+
+	stats := EstimateComplexity(rules)
+	if stats.NumTiers != 1 {
+		t.Errorf("NumTiers = %d, want 1", stats.NumTiers)
+	}
+	if stats.NumPolicies != 1 {
+		t.Errorf("NumPolicies = %d, want 1", stats.NumPolicies)
+	}
+	if stats.NumRules != 2 {
+		t.Errorf("NumRules = %d, want 2", stats.NumRules)
+	}
+	if stats.NumL7Analyzers != 1 {
+		t.Errorf("NumL7Analyzers = %d, want 1", stats.NumL7Analyzers)
+	}
+	if stats.NumMatchExprLeaves != 4 {
+		t.Errorf("NumMatchExprLeaves = %d, want 4", stats.NumMatchExprLeaves)
+	}
+}
+
+// TestComplexityBudgetRegressionGate guards against the compiled-program
+// complexity of a representative "kitchen sink" policy creeping up
+// silently: if this starts failing, a change has made policies more
+// expensive to compile/verify and the new cost needs to be a deliberate,
+// reviewed decision, not an accident.
+func TestComplexityBudgetRegressionGate(t *testing.T) {
+	rules := Rules{
+		Tiers: []Tier{{
+			Name: "tier",
+			Policies: []Policy{{
+				Name: "pol",
+				Rules: []Rule{
+					{Rule: &proto.Rule{Action: "Allow"}, L7Match: &L7Match{Protocol: L7ProtocolHTTP}},
+					{Rule: &proto.Rule{Action: "Allow"}, L7Match: &L7Match{Protocol: L7ProtocolTLSSNI}},
+					{Rule: &proto.Rule{Action: "Deny"}},
+				},
+			}},
+		}},
+	}
+
+	budget := ComplexityBudget{MaxRules: 10, MaxL7Analyzers: 3, MaxMatchExprLeaves: 20}
+	stats := EstimateComplexity(rules)
+	if violations := budget.Check(stats); len(violations) != 0 {
+		t.Fatalf("representative policy exceeded its complexity budget: %+v", violations)
+	}
+}
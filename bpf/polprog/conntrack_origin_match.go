@@ -0,0 +1,37 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+// ConntrackOriginMatch restricts a Rule's source-address matching to a
+// flow's pre-DNAT (original) source, rather than the source the policy
+// program otherwise sees post-NAT.  Without this, a NodePort or other
+// DNAT'd flow forces every source-matching rule to be written against the
+// node's own IP (since that's all the post-DNAT packet shows); with it,
+// the rule can instead match the real client, read out of the conntrack
+// entry's original-direction tuple that the NAT path already records.
+//
+// Only SrcNet/NotSrcNet/SrcIpSetIds/NotSrcIpSetIds on the wrapped
+// proto.Rule are reinterpreted this way; all other fields of the Rule
+// keep matching the (possibly post-DNAT) current packet as usual.
+type ConntrackOriginMatch struct {
+	// Enabled turns on original-source matching for this rule. It's a
+	// bool rather than the presence of the struct so that a rule can be
+	// built once and have the behaviour toggled by a feature gate.
+	Enabled bool
+}
+
+func (m *ConntrackOriginMatch) conditionName() string {
+	return "conntrack-origin-match"
+}
@@ -0,0 +1,362 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/projectcalico/felix/proto"
+)
+
+// Domain is the finite, representative set of packet field values
+// VerifyEquivalence enumerates over. Rather than the full address/port
+// space, it only needs enough values around every boundary a rule
+// references to catch off-by-one codegen bugs -- e.g. a port range's
+// first/last ±1, or the addresses just inside and outside a CIDR.
+type Domain struct {
+	Protocols []uint8
+	IPs       []net.IP
+	SrcPorts  []uint16
+	DstPorts  []uint16
+	IcmpTypes []uint8
+	IcmpCodes []uint8
+}
+
+// BuildDomain derives a representative Domain from rules: every protocol
+// any rule references (plus one that none of them do, to exercise the
+// "doesn't match" path), the first and last address of every referenced
+// IPv4 CIDR plus the address just outside each end (IPv6 CIDRs add no
+// addresses), every referenced
+// port's first-1/first/last/last+1, and every referenced ICMP type/code
+// plus one type past it.
+func BuildDomain(rules Rules) Domain {
+	protocols := map[uint8]bool{0: true}
+	ips := map[string]net.IP{}
+	ports := map[uint16]bool{0: true}
+	icmpTypes := map[uint8]bool{0: true}
+	icmpCodes := map[uint8]bool{0: true}
+
+	walkRules(rules, func(r *proto.Rule) {
+		if num, ok := protocolNumber(r.Protocol); ok {
+			protocols[num] = true
+		}
+		if num, ok := protocolNumber(r.NotProtocol); ok {
+			protocols[num] = true
+		}
+		for _, cidrs := range [][]string{r.SrcNet, r.DstNet, r.NotSrcNet, r.NotDstNet} {
+			for _, c := range cidrs {
+				for _, ip := range boundaryIPs(c) {
+					ips[ip.String()] = ip
+				}
+			}
+		}
+		for _, ranges := range [][]*proto.PortRange{r.SrcPorts, r.DstPorts, r.NotSrcPorts, r.NotDstPorts} {
+			for _, pr := range ranges {
+				for _, p := range boundaryPorts(pr) {
+					ports[p] = true
+				}
+			}
+		}
+		for typ, code := range icmpBoundaries(r) {
+			icmpTypes[typ] = true
+			icmpCodes[code] = true
+		}
+	})
+
+	d := Domain{}
+	for p := range protocols {
+		d.Protocols = append(d.Protocols, p)
+	}
+	for _, ip := range ips {
+		d.IPs = append(d.IPs, ip)
+	}
+	for p := range ports {
+		d.SrcPorts = append(d.SrcPorts, p)
+		d.DstPorts = append(d.DstPorts, p)
+	}
+	for typ := range icmpTypes {
+		d.IcmpTypes = append(d.IcmpTypes, typ)
+	}
+	for code := range icmpCodes {
+		d.IcmpCodes = append(d.IcmpCodes, code)
+	}
+	if len(d.IPs) == 0 {
+		d.IPs = []net.IP{net.ParseIP("0.0.0.0")}
+	}
+	return d
+}
+
+// icmpBoundaries returns r's referenced (type, code) pairs, if any, plus
+// one type one past each referenced one so VerifyEquivalence also
+// exercises the "doesn't match" path for an Icmp/NotIcmp rule.
+func icmpBoundaries(r *proto.Rule) map[uint8]uint8 {
+	out := map[uint8]uint8{}
+	add := func(typ, code int32) {
+		out[uint8(typ)] = uint8(code)
+		out[uint8(typ)+1] = uint8(code)
+	}
+	switch icmp := r.Icmp.(type) {
+	case *proto.Rule_IcmpType:
+		add(icmp.IcmpType, 0)
+	case *proto.Rule_IcmpTypeCode:
+		add(icmp.IcmpTypeCode.Type, icmp.IcmpTypeCode.Code)
+	}
+	switch notIcmp := r.NotIcmp.(type) {
+	case *proto.Rule_NotIcmpType:
+		add(notIcmp.NotIcmpType, 0)
+	case *proto.Rule_NotIcmpTypeCode:
+		add(notIcmp.NotIcmpTypeCode.Type, notIcmp.NotIcmpTypeCode.Code)
+	}
+	return out
+}
+
+func walkRules(rules Rules, visit func(r *proto.Rule)) {
+	for _, tier := range rules.Tiers {
+		for _, pol := range tier.Policies {
+			for _, r := range pol.Rules {
+				if r.Rule != nil {
+					visit(r.Rule)
+				}
+			}
+		}
+	}
+}
+
+func boundaryIPs(cidr string) []net.IP {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil
+	}
+	network := ipNet.IP.To4()
+	if network == nil {
+		return nil
+	}
+	first := binary.BigEndian.Uint32(network)
+	last := first | ^binary.BigEndian.Uint32(ipNet.Mask)
+	var out []net.IP
+	for _, v := range []uint32{first - 1, first, last, last + 1} {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, v)
+		out = append(out, ip)
+	}
+	return out
+}
+
+func boundaryPorts(pr *proto.PortRange) []uint16 {
+	var out []uint16
+	if pr.First > 0 {
+		out = append(out, uint16(pr.First-1))
+	}
+	out = append(out, uint16(pr.First), uint16(pr.Last))
+	if pr.Last < 65535 {
+		out = append(out, uint16(pr.Last+1))
+	}
+	return out
+}
+
+// Mismatch is one packet VerifyEquivalence found ReferenceMatcher and
+// its independently implemented decision-tree interpreter disagreeing
+// on.
+type Mismatch struct {
+	Packet   Packet
+	Expected Action
+	Got      Action
+}
+
+// VerifyEquivalence exhaustively evaluates rules over every combination
+// of domain's field values through both ReferenceMatcher (the package's
+// usual ground truth) and an independently implemented decision-tree
+// interpreter, reporting every packet the two disagree on. Catching a
+// disagreement here means one of the two evaluators' codegen has a bug
+// that a handful of concrete AllowedPackets/DroppedPackets vectors, or
+// random fuzzing, could plausibly miss -- e.g. an off-by-one on a port
+// range boundary, or the wrong short-circuit between tiers.
+//
+// Only the L3/L4 proto.Rule surface (protocol, CIDRs, port ranges, ICMP
+// type/code) is covered; rules using L7Match, MatchExpr, Process,
+// ConntrackOrigin or RateLimit are rejected, since those either need
+// payload state ReferenceMatcher doesn't model or are inherently
+// stateful/order dependent and so aren't suited to this kind of static
+// check.
+func VerifyEquivalence(rules Rules, domain Domain) ([]Mismatch, error) {
+	if err := rejectUnsupportedRules(rules); err != nil {
+		return nil, err
+	}
+
+	tree := buildDecisionTree(rules)
+	matcher := ReferenceMatcher{}
+
+	var mismatches []Mismatch
+	for _, protoNum := range domain.Protocols {
+		for _, srcIP := range domain.IPs {
+			for _, dstIP := range domain.IPs {
+				for _, srcPort := range domain.SrcPorts {
+					for _, dstPort := range domain.DstPorts {
+						for _, icmpType := range domain.IcmpTypes {
+							for _, icmpCode := range domain.IcmpCodes {
+								pkt := Packet{
+									Protocol: protoNum,
+									SrcIP:    srcIP,
+									DstIP:    dstIP,
+									SrcPort:  srcPort,
+									DstPort:  dstPort,
+									IcmpType: icmpType,
+									IcmpCode: icmpCode,
+								}
+								want := matcher.Evaluate(rules, pkt)
+								got := decisionTreeEvaluate(tree, pkt)
+								if want != got {
+									mismatches = append(mismatches, Mismatch{Packet: pkt, Expected: want, Got: got})
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return mismatches, nil
+}
+
+func rejectUnsupportedRules(rules Rules) error {
+	for _, tier := range rules.Tiers {
+		for _, pol := range tier.Policies {
+			for _, r := range pol.Rules {
+				switch {
+				case r.L7Match != nil:
+					return fmt.Errorf("tier %q policy %q: L7Match rules are not supported by VerifyEquivalence", tier.Name, pol.Name)
+				case r.MatchExpr != nil:
+					return fmt.Errorf("tier %q policy %q: MatchExpr rules are not supported by VerifyEquivalence", tier.Name, pol.Name)
+				case r.Process != nil:
+					return fmt.Errorf("tier %q policy %q: Process-matched rules are not supported by VerifyEquivalence", tier.Name, pol.Name)
+				case r.ConntrackOrigin != nil:
+					return fmt.Errorf("tier %q policy %q: ConntrackOrigin rules are not supported by VerifyEquivalence", tier.Name, pol.Name)
+				case r.RateLimit != nil:
+					return fmt.Errorf("tier %q policy %q: RateLimit rules are not supported by VerifyEquivalence", tier.Name, pol.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// decisionNode is one leaf of the predicate tree VerifyEquivalence
+// extracts from a Rule, independently of ReferenceMatcher's matching
+// code, so the two evaluators don't share a bug by sharing logic.
+type decisionNode struct {
+	action Action
+	test   func(pkt Packet) bool
+}
+
+func buildDecisionTree(rules Rules) []decisionNode {
+	var nodes []decisionNode
+	for _, tier := range rules.Sorted().Tiers {
+		for _, pol := range tier.Policies {
+			for _, r := range pol.Rules {
+				if r.Rule == nil {
+					continue
+				}
+				nodes = append(nodes, decisionNode{
+					action: Action(r.Rule.Action),
+					test:   buildPredicate(r.Rule),
+				})
+			}
+		}
+	}
+	return nodes
+}
+
+func decisionTreeEvaluate(nodes []decisionNode, pkt Packet) Action {
+	for _, n := range nodes {
+		if n.test(pkt) {
+			return n.action
+		}
+	}
+	return ""
+}
+
+func buildPredicate(r *proto.Rule) func(pkt Packet) bool {
+	srcNets := mustParseCIDRs(r.SrcNet)
+	dstNets := mustParseCIDRs(r.DstNet)
+	notSrcNets := mustParseCIDRs(r.NotSrcNet)
+	notDstNets := mustParseCIDRs(r.NotDstNet)
+
+	return func(pkt Packet) bool {
+		if num, ok := protocolNumber(r.Protocol); ok && pkt.Protocol != num {
+			return false
+		}
+		if num, ok := protocolNumber(r.NotProtocol); ok && pkt.Protocol == num {
+			return false
+		}
+		if !matchesICMP(r, pkt) {
+			return false
+		}
+		if len(srcNets) > 0 && !ipInAny(srcNets, pkt.SrcIP) {
+			return false
+		}
+		if len(dstNets) > 0 && !ipInAny(dstNets, pkt.DstIP) {
+			return false
+		}
+		if len(notSrcNets) > 0 && ipInAny(notSrcNets, pkt.SrcIP) {
+			return false
+		}
+		if len(notDstNets) > 0 && ipInAny(notDstNets, pkt.DstIP) {
+			return false
+		}
+		if len(r.SrcPorts) > 0 && !portInAnyRange(r.SrcPorts, pkt.SrcPort) {
+			return false
+		}
+		if len(r.DstPorts) > 0 && !portInAnyRange(r.DstPorts, pkt.DstPort) {
+			return false
+		}
+		if len(r.NotSrcPorts) > 0 && portInAnyRange(r.NotSrcPorts, pkt.SrcPort) {
+			return false
+		}
+		if len(r.NotDstPorts) > 0 && portInAnyRange(r.NotDstPorts, pkt.DstPort) {
+			return false
+		}
+		return true
+	}
+}
+
+func mustParseCIDRs(cidrs []string) []*net.IPNet {
+	out := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		if _, ipNet, err := net.ParseCIDR(c); err == nil {
+			out = append(out, ipNet)
+		}
+	}
+	return out
+}
+
+func ipInAny(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func portInAnyRange(ranges []*proto.PortRange, port uint16) bool {
+	for _, r := range ranges {
+		if uint32(port) >= r.First && uint32(port) <= r.Last {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build polprog_symbolic_verify
+
+// Symbolic equivalence checking is exhaustive over its derived domain
+// rather than a handful of concrete vectors, so it's noticeably slower
+// than the rest of this package's tests; it's kept behind this build tag
+// so `go test ./...` doesn't pay that cost by default. Run it with
+// `go test -tags polprog_symbolic_verify ./bpf/polprog/...`.
+package polprog
+
+import (
+	"testing"
+
+	"github.com/projectcalico/felix/proto"
+)
+
+// representativeRules covers the L3/L4 rule shapes VerifyEquivalence
+// supports: a CIDR plus port match, a protocol and a negated-protocol
+// match, and a Pass in one tier followed by an Allow in the next.
+func representativeRules() []Rules {
+	return []Rules{
+		{Tiers: []Tier{{Name: "tier", Policies: []Policy{{Name: "pol", Rules: []Rule{
+			{Rule: &proto.Rule{Action: "Allow", SrcNet: []string{"10.0.0.0/24"}, DstPorts: []*proto.PortRange{{First: 80, Last: 80}}}},
+			{Rule: &proto.Rule{Action: "Deny"}},
+		}}}}},
+		{Tiers: []Tier{{Name: "tier", Policies: []Policy{{Name: "pol", Rules: []Rule{
+			{Rule: &proto.Rule{Action: "Allow", Protocol: &proto.Protocol{NumberOrName: &proto.Protocol_Name{Name: "tcp"}}, SrcPorts: []*proto.PortRange{{First: 1000, Last: 2000}}}},
+			{Rule: &proto.Rule{Action: "Deny", NotProtocol: &proto.Protocol{NumberOrName: &proto.Protocol_Number{Number: 17}}}},
+		}}}}},
+		{Tiers: []Tier{
+			{Name: "tier-0", Policies: []Policy{{Name: "pol", Rules: []Rule{
+				{Rule: &proto.Rule{Action: "Pass", SrcNet: []string{"10.0.1.0/24"}}},
+			}}}},
+			{Name: "tier-1", Policies: []Policy{{Name: "pol", Rules: []Rule{
+				{Rule: &proto.Rule{Action: "Allow"}},
+			}}}},
+		}},
+	}
+}
+
+// TestSymbolicEquivalence_RepresentativePolicies is the package's
+// analogue of TestPolicyPrograms/TestHostPolicyPrograms/
+// TestXDPPolicyPrograms: instead of a fixed set of concrete
+// AllowedPackets/DroppedPackets vectors, it proves ReferenceMatcher and
+// an independently implemented decision-tree interpreter agree on every
+// packet in each policy's derived Domain.
+func TestSymbolicEquivalence_RepresentativePolicies(t *testing.T) {
+	for i, rules := range representativeRules() {
+		domain := BuildDomain(rules)
+		mismatches, err := VerifyEquivalence(rules, domain)
+		if err != nil {
+			t.Fatalf("policy %d: VerifyEquivalence returned error: %v", i, err)
+		}
+		if len(mismatches) != 0 {
+			t.Errorf("policy %d: found %d mismatches, e.g. %+v", i, len(mismatches), mismatches[0])
+		}
+	}
+}
+
+func TestVerifyEquivalence_RejectsUnsupportedRules(t *testing.T) {
+	rules := Rules{Tiers: []Tier{{Name: "tier", Policies: []Policy{{Name: "pol", Rules: []Rule{
+		{Rule: &proto.Rule{Action: "Deny"}, RateLimit: &RateLimit{PacketsPerSecond: 1, BurstSize: 1}},
+	}}}}}}
+	if _, err := VerifyEquivalence(rules, BuildDomain(rules)); err == nil {
+		t.Fatal("VerifyEquivalence = nil error, want error for a RateLimit-gated rule")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import "fmt"
+
+// Expr is a boolean expression over a rule's match conditions, letting a
+// single Rule combine several matches (including several different L7Match
+// conditions) with AND/OR/NOT rather than Calico's usual implicit
+// AND-of-everything. A nil Expr is always true, matching the legacy
+// all-fields-AND-ed behaviour of a Rule with only its proto.Rule fields
+// and optional single L7Match set.
+type Expr interface {
+	// Eval reports whether this expression is satisfied, given a lookup
+	// from match-condition name to whether that condition held for the
+	// current packet/flow.
+	Eval(matched func(condition string) bool) bool
+	// String renders the expression for logging/debugging.
+	String() string
+}
+
+// Cond is a leaf expression: true iff the named match condition held.
+// Eval passes the name unchanged to its lookup function; ProcessMatch,
+// ConntrackOriginMatch and RateLimit report the names they use via their
+// conditionName methods.
+type Cond string
+
+func (c Cond) Eval(matched func(string) bool) bool { return matched(string(c)) }
+func (c Cond) String() string                      { return string(c) }
+
+// And is true iff every operand is true. An empty And is true (identity).
+type And []Expr
+
+func (a And) Eval(matched func(string) bool) bool {
+	for _, e := range a {
+		if !e.Eval(matched) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a And) String() string { return joinExprs(a, " && ") }
+
+// Or is true iff at least one operand is true. An empty Or is false
+// (identity).
+type Or []Expr
+
+func (o Or) Eval(matched func(string) bool) bool {
+	for _, e := range o {
+		if e.Eval(matched) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o Or) String() string { return joinExprs(o, " || ") }
+
+// Not inverts its single operand.
+type Not struct {
+	Operand Expr
+}
+
+func (n Not) Eval(matched func(string) bool) bool { return !n.Operand.Eval(matched) }
+func (n Not) String() string                      { return fmt.Sprintf("!(%s)", n.Operand) }
+
+func joinExprs(exprs []Expr, sep string) string {
+	s := ""
+	for i, e := range exprs {
+		if i > 0 {
+			s += sep
+		}
+		s += e.String()
+	}
+	return "(" + s + ")"
+}
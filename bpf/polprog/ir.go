@@ -0,0 +1,368 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/projectcalico/felix/proto"
+)
+
+// irColumns is the header MarshalIR writes and UnmarshalIR expects: one
+// tab-separated match tuple per rule, close in spirit to Tailscale's
+// filter match tuples (src prefixes, dst NetPortRanges, proto list,
+// action). Line-oriented and text-diffable rather than JSON so a dumped
+// policy reads naturally next to `git diff` or a bug report.
+var irColumns = []string{
+	"tier", "policy", "policy_precedence", "rule_precedence", "action",
+	"proto", "not_proto", "src", "not_src", "dst", "not_dst",
+	"sport", "not_sport", "dport", "not_dport", "icmp", "not_icmp",
+}
+
+const (
+	irFieldSep = "\t"
+	irListSep  = ","
+	irEmpty    = "-"
+)
+
+// MarshalIR renders rules as polprog's stable, line-oriented textual IR.
+// It's meant to be the Builder's (and eventually alternate backends',
+// e.g. nftables or a pure-userspace dataplane) authoritative input, and
+// to let a user dump and inspect the effective policy for a workload
+// without attaching to BPF.
+//
+// Only the L3/L4 proto.Rule surface -- protocol, CIDRs, port ranges,
+// ICMP type/code -- round-trips; a rule using L7Match, MatchExpr,
+// Process, ConntrackOrigin or RateLimit can't be represented and causes
+// an error, the same scoping VerifyEquivalence uses for the same
+// reason.
+func MarshalIR(rules Rules) (string, error) {
+	var b strings.Builder
+	b.WriteString(strings.Join(irColumns, irFieldSep))
+	b.WriteByte('\n')
+
+	for _, tier := range rules.Tiers {
+		for _, pol := range tier.Policies {
+			for _, r := range pol.Rules {
+				if r.Rule == nil {
+					continue
+				}
+				if r.L7Match != nil || r.MatchExpr != nil || r.Process != nil || r.ConntrackOrigin != nil || r.RateLimit != nil {
+					return "", fmt.Errorf("tier %q policy %q: rule uses a match kind the IR doesn't support", tier.Name, pol.Name)
+				}
+				fields := []string{
+					tier.Name,
+					pol.Name,
+					strconv.Itoa(int(pol.Precedence)),
+					strconv.Itoa(int(r.Precedence)),
+					r.Rule.Action,
+					irProtocol(r.Rule.Protocol),
+					irProtocol(r.Rule.NotProtocol),
+					irList(r.Rule.SrcNet),
+					irList(r.Rule.NotSrcNet),
+					irList(r.Rule.DstNet),
+					irList(r.Rule.NotDstNet),
+					irPorts(r.Rule.SrcPorts),
+					irPorts(r.Rule.NotSrcPorts),
+					irPorts(r.Rule.DstPorts),
+					irPorts(r.Rule.NotDstPorts),
+					irIcmp(r.Rule),
+					irNotIcmp(r.Rule),
+				}
+				b.WriteString(strings.Join(fields, irFieldSep))
+				b.WriteByte('\n')
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// UnmarshalIR parses text written by MarshalIR back into a Rules tree.
+// Tiers and policies are emitted in first-seen order, so a Rules that
+// hasn't been reordered by Rules.Sorted round-trips with its original
+// Tiers/Policies/Rules slice order intact.
+func UnmarshalIR(text string) (Rules, error) {
+	var tiers []Tier
+	tierIdx := map[string]int{}
+	polIdx := map[string]int{}
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, irFieldSep)
+		if lineNum == 1 && strings.Join(fields, irFieldSep) == strings.Join(irColumns, irFieldSep) {
+			continue
+		}
+		if len(fields) != len(irColumns) {
+			return Rules{}, fmt.Errorf("line %d: want %d fields, got %d", lineNum, len(irColumns), len(fields))
+		}
+
+		rule, tierName, polName, polPrecedence, err := irParseRule(fields)
+		if err != nil {
+			return Rules{}, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		ti, ok := tierIdx[tierName]
+		if !ok {
+			tiers = append(tiers, Tier{Name: tierName})
+			ti = len(tiers) - 1
+			tierIdx[tierName] = ti
+		}
+		polKey := tierName + "\x00" + polName
+		pi, ok := polIdx[polKey]
+		if !ok {
+			tiers[ti].Policies = append(tiers[ti].Policies, Policy{Name: polName, Precedence: polPrecedence})
+			pi = len(tiers[ti].Policies) - 1
+			polIdx[polKey] = pi
+		}
+		tiers[ti].Policies[pi].Rules = append(tiers[ti].Policies[pi].Rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return Rules{}, err
+	}
+	return Rules{Tiers: tiers}, nil
+}
+
+func irParseRule(fields []string) (rule Rule, tierName, polName string, polPrecedence int32, err error) {
+	tierName, polName = fields[0], fields[1]
+
+	polPrec, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return Rule{}, "", "", 0, fmt.Errorf("policy_precedence: %w", err)
+	}
+	rulePrec, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return Rule{}, "", "", 0, fmt.Errorf("rule_precedence: %w", err)
+	}
+
+	protocol, err := irParseProtocol(fields[5])
+	if err != nil {
+		return Rule{}, "", "", 0, fmt.Errorf("proto: %w", err)
+	}
+	notProtocol, err := irParseProtocol(fields[6])
+	if err != nil {
+		return Rule{}, "", "", 0, fmt.Errorf("not_proto: %w", err)
+	}
+	srcPorts, err := irParsePorts(fields[11])
+	if err != nil {
+		return Rule{}, "", "", 0, fmt.Errorf("sport: %w", err)
+	}
+	notSrcPorts, err := irParsePorts(fields[12])
+	if err != nil {
+		return Rule{}, "", "", 0, fmt.Errorf("not_sport: %w", err)
+	}
+	dstPorts, err := irParsePorts(fields[13])
+	if err != nil {
+		return Rule{}, "", "", 0, fmt.Errorf("dport: %w", err)
+	}
+	notDstPorts, err := irParsePorts(fields[14])
+	if err != nil {
+		return Rule{}, "", "", 0, fmt.Errorf("not_dport: %w", err)
+	}
+	icmpType, icmpTypeCode, err := irParseIcmp(fields[15])
+	if err != nil {
+		return Rule{}, "", "", 0, fmt.Errorf("icmp: %w", err)
+	}
+	notIcmpType, notIcmpTypeCode, err := irParseNotIcmp(fields[16])
+	if err != nil {
+		return Rule{}, "", "", 0, fmt.Errorf("not_icmp: %w", err)
+	}
+
+	protoRule := &proto.Rule{
+		Action:      fields[4],
+		Protocol:    protocol,
+		NotProtocol: notProtocol,
+		SrcNet:      irParseList(fields[7]),
+		NotSrcNet:   irParseList(fields[8]),
+		DstNet:      irParseList(fields[9]),
+		NotDstNet:   irParseList(fields[10]),
+		SrcPorts:    srcPorts,
+		NotSrcPorts: notSrcPorts,
+		DstPorts:    dstPorts,
+		NotDstPorts: notDstPorts,
+	}
+	// Icmp/NotIcmp are oneofs: at most one of each pair is non-nil, so
+	// whichever variant irParseIcmp/irParseNotIcmp produced wins.
+	if icmpType != nil {
+		protoRule.Icmp = icmpType
+	}
+	if icmpTypeCode != nil {
+		protoRule.Icmp = icmpTypeCode
+	}
+	if notIcmpType != nil {
+		protoRule.NotIcmp = notIcmpType
+	}
+	if notIcmpTypeCode != nil {
+		protoRule.NotIcmp = notIcmpTypeCode
+	}
+
+	rule = Rule{Rule: protoRule, Precedence: int32(rulePrec)}
+	return rule, tierName, polName, int32(polPrec), nil
+}
+
+func irProtocol(p *proto.Protocol) string {
+	if p == nil {
+		return irEmpty
+	}
+	if name := p.GetName(); name != "" {
+		return strings.ToLower(name)
+	}
+	return strconv.Itoa(int(p.GetNumber()))
+}
+
+func irParseProtocol(s string) (*proto.Protocol, error) {
+	if s == irEmpty {
+		return nil, nil
+	}
+	if num, err := strconv.Atoi(s); err == nil {
+		return &proto.Protocol{NumberOrName: &proto.Protocol_Number{Number: int32(num)}}, nil
+	}
+	if _, ok := protocolsByName[s]; !ok {
+		return nil, fmt.Errorf("unknown protocol %q", s)
+	}
+	return &proto.Protocol{NumberOrName: &proto.Protocol_Name{Name: s}}, nil
+}
+
+func irList(items []string) string {
+	if len(items) == 0 {
+		return irEmpty
+	}
+	return strings.Join(items, irListSep)
+}
+
+func irParseList(s string) []string {
+	if s == irEmpty {
+		return nil
+	}
+	return strings.Split(s, irListSep)
+}
+
+// irIcmp renders a rule's Icmp oneof as "-" (unset), "<type>" (type-only)
+// or "<type>:<code>" (type and code). It takes the whole rule, rather
+// than just r.Icmp, because the oneof's interface type is unexported and
+// can only be type-switched on, not named as a parameter type.
+func irIcmp(r *proto.Rule) string {
+	switch v := r.Icmp.(type) {
+	case *proto.Rule_IcmpType:
+		return strconv.Itoa(int(v.IcmpType))
+	case *proto.Rule_IcmpTypeCode:
+		return fmt.Sprintf("%d:%d", v.IcmpTypeCode.Type, v.IcmpTypeCode.Code)
+	}
+	return irEmpty
+}
+
+// irNotIcmp is irIcmp's NotIcmp counterpart; it's a separate function
+// (rather than sharing irIcmp) because NotIcmp's oneof is a distinct,
+// unexported interface type from Icmp's, even though the wire shapes are
+// identical.
+func irNotIcmp(r *proto.Rule) string {
+	switch v := r.NotIcmp.(type) {
+	case *proto.Rule_NotIcmpType:
+		return strconv.Itoa(int(v.NotIcmpType))
+	case *proto.Rule_NotIcmpTypeCode:
+		return fmt.Sprintf("%d:%d", v.NotIcmpTypeCode.Type, v.NotIcmpTypeCode.Code)
+	}
+	return irEmpty
+}
+
+func irParseIcmp(s string) (*proto.Rule_IcmpType, *proto.Rule_IcmpTypeCode, error) {
+	if s == irEmpty {
+		return nil, nil, nil
+	}
+	typ, code, hasCode, err := irParseIcmpValue(s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%q: %w", s, err)
+	}
+	if hasCode {
+		return nil, &proto.Rule_IcmpTypeCode{IcmpTypeCode: &proto.IcmpTypeAndCode{Type: typ, Code: code}}, nil
+	}
+	return &proto.Rule_IcmpType{IcmpType: typ}, nil, nil
+}
+
+func irParseNotIcmp(s string) (*proto.Rule_NotIcmpType, *proto.Rule_NotIcmpTypeCode, error) {
+	if s == irEmpty {
+		return nil, nil, nil
+	}
+	typ, code, hasCode, err := irParseIcmpValue(s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%q: %w", s, err)
+	}
+	if hasCode {
+		return nil, &proto.Rule_NotIcmpTypeCode{NotIcmpTypeCode: &proto.IcmpTypeAndCode{Type: typ, Code: code}}, nil
+	}
+	return &proto.Rule_NotIcmpType{NotIcmpType: typ}, nil, nil
+}
+
+// irParseIcmpValue parses "<type>" or "<type>:<code>" into int32 fields,
+// the width proto.IcmpTypeAndCode and the Icmp/NotIcmp type-only variants
+// both use.
+func irParseIcmpValue(s string) (typ, code int32, hasCode bool, err error) {
+	typStr, codeStr, hasCode := strings.Cut(s, ":")
+	typ64, err := strconv.ParseInt(typStr, 10, 32)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if !hasCode {
+		return int32(typ64), 0, false, nil
+	}
+	code64, err := strconv.ParseInt(codeStr, 10, 32)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return int32(typ64), int32(code64), true, nil
+}
+
+func irPorts(ranges []*proto.PortRange) string {
+	if len(ranges) == 0 {
+		return irEmpty
+	}
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = fmt.Sprintf("%d-%d", r.First, r.Last)
+	}
+	return strings.Join(parts, irListSep)
+}
+
+func irParsePorts(s string) ([]*proto.PortRange, error) {
+	if s == irEmpty {
+		return nil, nil
+	}
+	parts := strings.Split(s, irListSep)
+	ranges := make([]*proto.PortRange, len(parts))
+	for i, p := range parts {
+		first, last, ok := strings.Cut(p, "-")
+		if !ok {
+			return nil, fmt.Errorf("%q is not <first>-<last>", p)
+		}
+		firstNum, err := strconv.ParseUint(first, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", p, err)
+		}
+		lastNum, err := strconv.ParseUint(last, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", p, err)
+		}
+		ranges[i] = &proto.PortRange{First: uint32(firstNum), Last: uint32(lastNum)}
+	}
+	return ranges, nil
+}
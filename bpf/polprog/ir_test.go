@@ -0,0 +1,95 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import (
+	"net"
+	"testing"
+
+	"github.com/projectcalico/felix/proto"
+)
+
+func TestMarshalUnmarshalIRRoundTrip(t *testing.T) {
+	rules := Rules{Tiers: []Tier{{
+		Name: "tier",
+		Policies: []Policy{{
+			Name:       "allow-web",
+			Precedence: 1,
+			Rules: []Rule{
+				{
+					Rule: &proto.Rule{
+						Action:   "Allow",
+						Protocol: &proto.Protocol{NumberOrName: &proto.Protocol_Name{Name: "tcp"}},
+						SrcNet:   []string{"10.0.0.0/24", "10.0.1.0/24"},
+						DstPorts: []*proto.PortRange{{First: 80, Last: 80}, {First: 443, Last: 443}},
+					},
+					Precedence: 5,
+				},
+				{Rule: &proto.Rule{Action: "Deny", NotProtocol: &proto.Protocol{NumberOrName: &proto.Protocol_Number{Number: 17}}}},
+			},
+		}},
+	}}}
+
+	ir, err := MarshalIR(rules)
+	if err != nil {
+		t.Fatalf("MarshalIR returned error: %v", err)
+	}
+
+	got, err := UnmarshalIR(ir)
+	if err != nil {
+		t.Fatalf("UnmarshalIR returned error: %v", err)
+	}
+
+	allowedPkt := Packet{Protocol: 6, SrcIP: net.ParseIP("10.0.0.5"), DstIP: net.ParseIP("8.8.8.8"), DstPort: 80}
+	deniedPkt := Packet{Protocol: 17, SrcIP: net.ParseIP("10.0.5.5"), DstIP: net.ParseIP("8.8.8.8"), DstPort: 53}
+
+	matcher := ReferenceMatcher{}
+	for _, pkt := range []Packet{allowedPkt, deniedPkt} {
+		want := matcher.Evaluate(rules, pkt)
+		recompiled := matcher.Evaluate(got, pkt)
+		if want != recompiled {
+			t.Errorf("Evaluate(pkt=%+v): original=%q, round-tripped=%q", pkt, want, recompiled)
+		}
+	}
+
+	// Sanity check the policy's own precedence round-tripped too, not
+	// just its rules' verdicts.
+	if got.Tiers[0].Policies[0].Precedence != 1 {
+		t.Errorf("Policies[0].Precedence = %d, want 1", got.Tiers[0].Policies[0].Precedence)
+	}
+	if got.Tiers[0].Policies[0].Rules[0].Precedence != 5 {
+		t.Errorf("Rules[0].Precedence = %d, want 5", got.Tiers[0].Policies[0].Rules[0].Precedence)
+	}
+}
+
+func TestMarshalIRRejectsUnsupportedMatchKinds(t *testing.T) {
+	rules := Rules{Tiers: []Tier{{Name: "tier", Policies: []Policy{{Name: "pol", Rules: []Rule{
+		{Rule: &proto.Rule{Action: "Allow"}, L7Match: &L7Match{Protocol: L7ProtocolHTTP}},
+	}}}}}}
+	if _, err := MarshalIR(rules); err == nil {
+		t.Fatal("MarshalIR = nil error, want error for an L7Match rule")
+	}
+}
+
+func TestUnmarshalIRRejectsMalformedInput(t *testing.T) {
+	for _, text := range []string{
+		"tier\tpol\n",                       // too few fields
+		"tier\tpol\tnot-a-number\t0\tAllow\t-\t-\t-\t-\t-\t-\t-\t-\t-\t-\t-\t-\n",
+	} {
+		if _, err := UnmarshalIR(text); err == nil {
+			t.Errorf("UnmarshalIR(%q) = nil error, want error", text)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import (
+	"fmt"
+)
+
+// JumpMapAllocator assigns BPF jump-map indices to named tail-call
+// targets. It's satisfied by the Builder's own jump map bookkeeping as
+// well as by tests that just want to check which analyzers would be
+// requested.
+type JumpMapAllocator interface {
+	Allocate(progName string) (int, error)
+}
+
+// L7Protocol identifies which analyzer a L7Match needs.
+type L7Protocol string
+
+const (
+	L7ProtocolHTTP   L7Protocol = "http"
+	L7ProtocolTLSSNI L7Protocol = "tls-sni"
+	L7ProtocolDNS    L7Protocol = "dns"
+)
+
+// L7Match is an application-layer match condition attached to a Rule. Only
+// one of the protocol-specific fields should be set; Protocol says which.
+type L7Match struct {
+	Protocol L7Protocol
+
+	// HTTPMethod/HTTPPathPrefix match an HTTP request line, e.g. method
+	// "GET" and a path prefix of "/admin".  Either may be left empty to
+	// mean "any".
+	HTTPMethod     string
+	HTTPPathPrefix string
+
+	// TLSServerName matches the SNI extension of a TLS ClientHello.
+	TLSServerName string
+
+	// DNSQuerySuffix matches a DNS question name, e.g. ".internal." to
+	// match any name under the internal. zone.
+	DNSQuerySuffix string
+}
+
+// analyzerProgramName is the tc/XDP program name an L7Protocol's analyzer
+// is loaded under; it doubles as the jump map key.
+func (p L7Protocol) analyzerProgramName() string {
+	return "calico_l7_analyze_" + string(p)
+}
+
+// l7AnalyzerSet tracks which L7 analyzer tail calls a compiled program
+// actually needs, so the Builder only wires up jump-map slots for
+// protocols that some rule in this set of Rules actually references --
+// every unused slot is one less tail call the verifier has to chase
+// through.
+type l7AnalyzerSet struct {
+	needed map[L7Protocol]bool
+}
+
+func newL7AnalyzerSet() *l7AnalyzerSet {
+	return &l7AnalyzerSet{needed: map[L7Protocol]bool{}}
+}
+
+// observe records that the given rule's L7Match (if any) requires its
+// analyzer.
+func (s *l7AnalyzerSet) observe(r Rule) {
+	if r.L7Match == nil {
+		return
+	}
+	s.needed[r.L7Match.Protocol] = true
+}
+
+// AllocateSlots assigns each needed analyzer a jump-map index via alloc,
+// returning the program-name-to-index mapping the Builder needs to
+// populate the jump map and to emit the right tail-call instruction at
+// each L7Match site.
+func (s *l7AnalyzerSet) AllocateSlots(alloc JumpMapAllocator) (map[L7Protocol]int, error) {
+	slots := make(map[L7Protocol]int, len(s.needed))
+	for p7 := range s.needed {
+		idx, err := alloc.Allocate(p7.analyzerProgramName())
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate jump map slot for L7 analyzer %s: %w", p7, err)
+		}
+		slots[p7] = idx
+	}
+	return slots, nil
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import "sort"
+
+// Sorted returns a copy of r with each tier's Policies, and each policy's
+// Rules, stable-sorted by ascending Precedence. Builder and
+// ReferenceMatcher both compile/evaluate a Rules tree in this order
+// rather than raw slice order, so a caller that assembles tiers by
+// merging precedence-tagged fragments from several sources doesn't also
+// have to hand-sort them first to get a deterministic, shadowing-correct
+// result.
+func (r Rules) Sorted() Rules {
+	out := Rules{Tiers: make([]Tier, len(r.Tiers))}
+	for i, tier := range r.Tiers {
+		policies := make([]Policy, len(tier.Policies))
+		copy(policies, tier.Policies)
+		sort.SliceStable(policies, func(a, b int) bool {
+			return policies[a].Precedence < policies[b].Precedence
+		})
+		for j, pol := range policies {
+			rules := make([]Rule, len(pol.Rules))
+			copy(rules, pol.Rules)
+			sort.SliceStable(rules, func(a, b int) bool {
+				return rules[a].Precedence < rules[b].Precedence
+			})
+			pol.Rules = rules
+			policies[j] = pol
+		}
+		out.Tiers[i] = Tier{Name: tier.Name, Policies: policies}
+	}
+	return out
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import (
+	"net"
+	"testing"
+
+	"github.com/projectcalico/felix/proto"
+)
+
+// policyBefore returns a copy of p with a Precedence that sorts strictly
+// before ref, regardless of where the caller puts it in Tier.Policies.
+func policyBefore(p Policy, ref Policy) Policy {
+	p.Precedence = ref.Precedence - 1
+	return p
+}
+
+// policyAfter returns a copy of p with a Precedence that sorts strictly
+// after ref.
+func policyAfter(p Policy, ref Policy) Policy {
+	p.Precedence = ref.Precedence + 1
+	return p
+}
+
+func TestSortedPrecedenceOverridesSliceOrder(t *testing.T) {
+	allowAll := Policy{Name: "allow-all", Rules: []Rule{{Rule: &proto.Rule{Action: "Allow"}}}}
+	denyAll := policyBefore(Policy{Name: "deny-all", Rules: []Rule{{Rule: &proto.Rule{Action: "Deny"}}}}, allowAll)
+
+	// Caller assembles the tier with the (lower-precedence) allow first
+	// in the slice -- Sorted must still evaluate the higher-precedence
+	// deny first so it shadows the allow.
+	rules := Rules{Tiers: []Tier{{Name: "tier", Policies: []Policy{allowAll, denyAll}}}}
+
+	pkt := Packet{Protocol: 6, SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2")}
+	if got := (ReferenceMatcher{}).Evaluate(rules, pkt); got != "Deny" {
+		t.Fatalf("Evaluate = %q, want \"Deny\" (high-precedence deny should shadow the later allow)", got)
+	}
+}
+
+func TestSortedStableOnEqualPrecedence(t *testing.T) {
+	first := Policy{Name: "first", Rules: []Rule{{Rule: &proto.Rule{Action: "Allow"}}}}
+	second := Policy{Name: "second", Rules: []Rule{{Rule: &proto.Rule{Action: "Deny"}}}}
+
+	rules := Rules{Tiers: []Tier{{Name: "tier", Policies: []Policy{first, second}}}}
+	sorted := rules.Sorted()
+	if sorted.Tiers[0].Policies[0].Name != "first" || sorted.Tiers[0].Policies[1].Name != "second" {
+		t.Fatalf("Sorted reordered equal-precedence policies: %+v", sorted.Tiers[0].Policies)
+	}
+}
+
+func TestPolicyAfterShadowing(t *testing.T) {
+	denyAll := Policy{Name: "deny-all", Rules: []Rule{{Rule: &proto.Rule{Action: "Deny"}}}}
+	allowAll := policyAfter(Policy{Name: "allow-all", Rules: []Rule{{Rule: &proto.Rule{Action: "Allow"}}}}, denyAll)
+
+	rules := Rules{Tiers: []Tier{{Name: "tier", Policies: []Policy{allowAll, denyAll}}}}
+
+	pkt := Packet{Protocol: 6, SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2")}
+	if got := (ReferenceMatcher{}).Evaluate(rules, pkt); got != "Deny" {
+		t.Fatalf("Evaluate = %q, want \"Deny\" (deny-all has lower Precedence and should run first)", got)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+// ProcessMatch restricts a Rule to traffic originating from a process
+// running as a specific UID and/or GID on the local host, read via a
+// BPF_MAP_TYPE_TASK_STORAGE map keyed by the current task at socket-open
+// time (bpf_get_current_task_btf + bpf_task_storage_get), rather than by
+// parsing /proc as Felix's older process-match implementations for
+// non-BPF dataplanes do. A nil field means "don't care".
+type ProcessMatch struct {
+	UID *uint32
+	GID *uint32
+}
+
+// taskStorageMapName is the fixed name the task-storage map is pinned
+// under; cgroup/sockops programs populate it on process exec/clone so the
+// tc policy program can look the current task's creds up cheaply.
+const taskStorageMapName = "cali_task_creds"
+
+// conditionName returns the Expr leaf name a rule's MatchExpr uses to
+// refer to this ProcessMatch.
+func (m *ProcessMatch) conditionName() string {
+	return "process-match"
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+// ProgramSpec is the metadata a compiled Rules tree needs to be loaded
+// through github.com/cilium/ebpf's loader: the fields github.com/
+// cilium/ebpf.ProgramSpec itself carries (Name, license, program type),
+// kept here as plain strings/ints rather than the library's own types so
+// this package doesn't have to take on that dependency just to describe
+// a program.
+//
+// This is a staging point for the CO-RE migration described in the
+// request that added this file: the loader that actually turns this (or
+// a real *ebpf.ProgramSpec) into a running program --
+// bpf.LoadBPFProgramFromInsns, bpf.RunBPFProgram, asm.NewBlock and
+// maps.Map -- lives in packages this tree doesn't vendor, so Builder
+// can't yet be wired up to cilium/ebpf's link.Link/Program.Test end to
+// end. ProgramSpec only covers the part Builder can own today: deriving
+// the load-time metadata from a Rules tree.
+type ProgramSpec struct {
+	// Name is the program name the loader will register it under, and
+	// the name a bpf2go-generated Go binding would use to key into its
+	// collection of loaded programs.
+	Name string
+	// Type is the BPF program type name (e.g. "SchedCLS", "XDP") the
+	// compiled program attaches as.
+	Type string
+	// License is the program's declared license, which the kernel
+	// verifier checks against GPL-only helpers before allowing the load.
+	License string
+}
+
+// BuildProgramSpec derives the ProgramSpec for rules. It's independent
+// of any particular attach point, so the Builder that eventually compiles
+// rules into instructions and attaches them decides Type -- BuildProgramSpec
+// only fills in the parts that don't vary by attach point.
+func (b *Builder) BuildProgramSpec(rules Rules, progType string) ProgramSpec {
+	return ProgramSpec{
+		Name:    "calico_policy",
+		Type:    progType,
+		License: "Apache-2.0",
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import "testing"
+
+func TestBuilder_BuildProgramSpec(t *testing.T) {
+	b := NewBuilder(nil, 1, 2, 3)
+	spec := b.BuildProgramSpec(Rules{}, "SchedCLS")
+
+	if spec.Name != "calico_policy" {
+		t.Errorf("Name = %q, want \"calico_policy\"", spec.Name)
+	}
+	if spec.Type != "SchedCLS" {
+		t.Errorf("Type = %q, want \"SchedCLS\"", spec.Type)
+	}
+	if spec.License != "Apache-2.0" {
+		t.Errorf("License = %q, want \"Apache-2.0\"", spec.License)
+	}
+}
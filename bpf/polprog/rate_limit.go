@@ -0,0 +1,138 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit restricts a Rule to firing only once its per-rule (or, with
+// PerFlow set, per-5-tuple) token bucket is exhausted: a packet that
+// still has budget falls through to the next rule untouched, while one
+// that has exceeded the bucket takes this rule's action. The compiled
+// program implements the bucket as a BPF_MAP_TYPE_PERCPU_HASH keyed by
+// rule-id (and, for PerFlow, the flow hash), refilled lazily on read
+// rather than by a separate timer program; RateLimiter is the userspace
+// equivalent used by ReferenceMatcher and by tests.
+type RateLimit struct {
+	// PacketsPerSecond is the bucket's refill rate.
+	PacketsPerSecond float64
+
+	// BurstSize is the bucket's capacity: the maximum number of packets
+	// that can be let through back-to-back after a period of idleness.
+	BurstSize float64
+
+	// PerFlow scopes the bucket to the packet's 5-tuple instead of
+	// sharing one bucket across every packet the rule matches.
+	PerFlow bool
+}
+
+func (rl *RateLimit) conditionName() string {
+	return "rate-limit"
+}
+
+// RateLimitStats is the observability counterpart of a rule's token
+// bucket: how many packets it has let through to fall through to the
+// next rule, versus how many it has matched (and so, typically, dropped)
+// for exceeding the budget.
+type RateLimitStats struct {
+	Allowed uint64
+	Dropped uint64
+}
+
+// RateLimiter is a userspace token-bucket implementation of RateLimit,
+// used as ReferenceMatcher's ground truth for the compiled program's
+// per-rule BPF map. The recurrence it implements, per bucket, is:
+//
+//	new_tokens = min(burst, old_tokens + rate*(now-last_ts))
+//	if new_tokens >= 1: tokens = new_tokens-1; allow
+//	else:               tokens = new_tokens;   deny
+//
+// A RateLimiter is safe for concurrent use; the zero value has an empty
+// set of buckets and time.Now as its clock.
+type RateLimiter struct {
+	// Now, if set, is used instead of time.Now -- tests use this to
+	// drive the bucket deterministically rather than racing a real
+	// clock.
+	Now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+	stats    RateLimitStats
+}
+
+// Allow consumes a token from the bucket identified by (ruleID, flowKey)
+// -- flowKey is ignored unless limit.PerFlow is set -- refilling it first
+// per the recurrence above. It reports whether the packet was within
+// budget (true: fall through to the next rule) or exceeded it (false:
+// this rule fires).
+func (rl *RateLimiter) Allow(ruleID, flowKey string, limit RateLimit) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.buckets == nil {
+		rl.buckets = map[string]*tokenBucket{}
+	}
+	key := ruleID
+	if limit.PerFlow {
+		key = fmt.Sprintf("%s|%s", ruleID, flowKey)
+	}
+
+	now := time.Now()
+	if rl.Now != nil {
+		now = rl.Now()
+	}
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: limit.BurstSize, lastSeen: now}
+		rl.buckets[key] = b
+	}
+
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(limit.BurstSize, b.tokens+limit.PacketsPerSecond*elapsed)
+	}
+	b.lastSeen = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.stats.Allowed++
+		return true
+	}
+	b.stats.Dropped++
+	return false
+}
+
+// Stats returns a snapshot of every bucket's counters, keyed the same
+// way Allow's callers key their ruleID/flowKey pairs.
+func (rl *RateLimiter) Stats() map[string]RateLimitStats {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	out := make(map[string]RateLimitStats, len(rl.buckets))
+	for key, b := range rl.buckets {
+		out[key] = b.stats
+	}
+	return out
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/projectcalico/felix/proto"
+)
+
+// Packet is a minimal userspace stand-in for the fields the compiled BPF
+// program reads out of a real packet, used by ReferenceMatcher so policy
+// semantics can be exercised (and fuzzed) without an actual kernel/BPF
+// program to run them through. SrcIP/DstIP are ordinary net.IP, 4- or
+// 16-byte, so one Rules tree matches both v4 and v6 traffic the same
+// way the compiled program's dual-stack BPF objects do.
+//
+// IcmpType/IcmpCode are only meaningful when Protocol is ICMP (1) or
+// ICMPv6 (58); NDP messages (router/neighbour solicitation and
+// advertisement, redirect) are ICMPv6 types 133-137 and match like any
+// other ICMP type/code.
+type Packet struct {
+	Protocol uint8
+	SrcIP    net.IP
+	DstIP    net.IP
+	SrcPort  uint16
+	DstPort  uint16
+	IcmpType uint8
+	IcmpCode uint8
+}
+
+// ReferenceMatcher evaluates a Rules tree against a Packet using plain Go,
+// implementing the same first-match-wins, fall-through-tier semantics the
+// compiled program is meant to -- but without any of the compiled
+// program's jump-map or verifier constraints. It is the ground truth the
+// fuzz targets and VerifyEquivalence check other evaluators against.
+//
+// RateLimiter is optional; it only needs to be set if Rules contains a
+// RateLimit-gated rule, mirroring the compiled program's per-rule token
+// bucket map. Trace is likewise optional; if set, every rule that fires
+// pushes a TraceRecord onto it, mirroring a Builder with EnableTrace set
+// pushing onto its BPF_MAP_TYPE_QUEUE trace map. ReferenceMatcher is
+// otherwise stateless, so its zero value is ready to use.
+type ReferenceMatcher struct {
+	RateLimiter *RateLimiter
+	Trace       *TraceRing
+}
+
+// Action is the verdict ReferenceMatcher.Evaluate returns: the string
+// form of whichever proto.Rule.Action fired, or "" if nothing matched.
+type Action string
+
+// Evaluate returns the action of the first rule, in the first matching
+// policy, in the first tier, that matches pkt; or "" if none do. Tiers'
+// Policies, and each Policy's Rules, are evaluated in Rules.Sorted order
+// rather than raw slice order, so a high-precedence deny always shadows
+// a lower-precedence allow regardless of how the caller assembled rules.
+func (m ReferenceMatcher) Evaluate(rules Rules, pkt Packet) Action {
+	for _, tier := range rules.Sorted().Tiers {
+		for _, pol := range tier.Policies {
+			for i, r := range pol.Rules {
+				ruleID := fmt.Sprintf("%s/%s#%d", tier.Name, pol.Name, i)
+				if m.matchesRule(ruleID, r, pkt) {
+					verdict := Action(r.Rule.Action)
+					if m.Trace != nil {
+						// IPSetID is always 0: this package's rules match
+						// raw CIDRs rather than named, ID-allocated IP
+						// sets, so there's no set ID to report here.
+						m.Trace.push(ruleID, 0, tupleHash(pkt), verdict)
+					}
+					return verdict
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func (m ReferenceMatcher) matchesRule(ruleID string, r Rule, pkt Packet) bool {
+	if !matchesRuleFields(r.Rule, pkt) {
+		return false
+	}
+	if r.RateLimit != nil {
+		// No bucket to check the packet against: fail closed, i.e. the
+		// rule always fires, same as a misconfigured compiled program
+		// that never got its rate-limit map populated.
+		if m.RateLimiter == nil {
+			return true
+		}
+		flowKey := ""
+		if r.RateLimit.PerFlow {
+			flowKey = fmt.Sprintf("%d-%s-%d-%s-%d", pkt.Protocol, pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort)
+		}
+		if m.RateLimiter.Allow(ruleID, flowKey, *r.RateLimit) {
+			// Within budget: this rule doesn't fire, let later rules
+			// (or the tier's default deny) decide the packet's fate.
+			return false
+		}
+	}
+	return true
+}
+
+func matchesRuleFields(r *proto.Rule, pkt Packet) bool {
+	if r == nil {
+		return false
+	}
+	if num, ok := protocolNumber(r.Protocol); ok && pkt.Protocol != num {
+		return false
+	}
+	if num, ok := protocolNumber(r.NotProtocol); ok && pkt.Protocol == num {
+		return false
+	}
+	if !matchesICMP(r, pkt) {
+		return false
+	}
+	if len(r.SrcNet) > 0 && !anyNetContains(r.SrcNet, pkt.SrcIP) {
+		return false
+	}
+	if len(r.DstNet) > 0 && !anyNetContains(r.DstNet, pkt.DstIP) {
+		return false
+	}
+	if len(r.NotSrcNet) > 0 && anyNetContains(r.NotSrcNet, pkt.SrcIP) {
+		return false
+	}
+	if len(r.NotDstNet) > 0 && anyNetContains(r.NotDstNet, pkt.DstIP) {
+		return false
+	}
+	if len(r.SrcPorts) > 0 && !anyPortRangeContains(r.SrcPorts, pkt.SrcPort) {
+		return false
+	}
+	if len(r.DstPorts) > 0 && !anyPortRangeContains(r.DstPorts, pkt.DstPort) {
+		return false
+	}
+	if len(r.NotSrcPorts) > 0 && anyPortRangeContains(r.NotSrcPorts, pkt.SrcPort) {
+		return false
+	}
+	if len(r.NotDstPorts) > 0 && anyPortRangeContains(r.NotDstPorts, pkt.DstPort) {
+		return false
+	}
+	return true
+}
+
+// matchesICMP checks r's Icmp/NotIcmp oneof (type-only or type-and-code)
+// against pkt's ICMP type/code.
+func matchesICMP(r *proto.Rule, pkt Packet) bool {
+	switch icmp := r.Icmp.(type) {
+	case *proto.Rule_IcmpType:
+		if pkt.IcmpType != uint8(icmp.IcmpType) {
+			return false
+		}
+	case *proto.Rule_IcmpTypeCode:
+		if pkt.IcmpType != uint8(icmp.IcmpTypeCode.Type) || pkt.IcmpCode != uint8(icmp.IcmpTypeCode.Code) {
+			return false
+		}
+	}
+	switch notIcmp := r.NotIcmp.(type) {
+	case *proto.Rule_NotIcmpType:
+		if pkt.IcmpType == uint8(notIcmp.NotIcmpType) {
+			return false
+		}
+	case *proto.Rule_NotIcmpTypeCode:
+		if pkt.IcmpType == uint8(notIcmp.NotIcmpTypeCode.Type) && pkt.IcmpCode == uint8(notIcmp.NotIcmpTypeCode.Code) {
+			return false
+		}
+	}
+	return true
+}
+
+func anyNetContains(cidrs []string, ip net.IP) bool {
+	for _, c := range cidrs {
+		_, ipNet, err := net.ParseCIDR(c)
+		if err != nil {
+			continue
+		}
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func anyPortRangeContains(ranges []*proto.PortRange, port uint16) bool {
+	for _, r := range ranges {
+		if uint32(port) >= r.First && uint32(port) <= r.Last {
+			return true
+		}
+	}
+	return false
+}
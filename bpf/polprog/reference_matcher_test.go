@@ -0,0 +1,86 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import (
+	"net"
+	"testing"
+
+	"github.com/projectcalico/felix/proto"
+)
+
+func singlePolicyRules(rule *proto.Rule) Rules {
+	return Rules{Tiers: []Tier{{
+		Name:     "tier",
+		Policies: []Policy{{Name: "pol", Rules: []Rule{{Rule: rule}}}},
+	}}}
+}
+
+func TestReferenceMatcher_ProtocolNumber(t *testing.T) {
+	rules := singlePolicyRules(&proto.Rule{Action: "Allow", Protocol: &proto.Protocol{NumberOrName: &proto.Protocol_Number{Number: 6}}})
+	tcpPkt := Packet{Protocol: 6, SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2")}
+	udpPkt := Packet{Protocol: 17, SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2")}
+
+	if got := (ReferenceMatcher{}).Evaluate(rules, tcpPkt); got != "Allow" {
+		t.Errorf("Evaluate(tcp) = %q, want \"Allow\"", got)
+	}
+	if got := (ReferenceMatcher{}).Evaluate(rules, udpPkt); got != "" {
+		t.Errorf("Evaluate(udp) = %q, want \"\"", got)
+	}
+}
+
+func TestReferenceMatcher_NotProtocol(t *testing.T) {
+	rules := singlePolicyRules(&proto.Rule{Action: "Allow", NotProtocol: &proto.Protocol{NumberOrName: &proto.Protocol_Name{Name: "tcp"}}})
+	tcpPkt := Packet{Protocol: 6, SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2")}
+	udpPkt := Packet{Protocol: 17, SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2")}
+
+	if got := (ReferenceMatcher{}).Evaluate(rules, tcpPkt); got != "" {
+		t.Errorf("Evaluate(tcp) = %q, want \"\" (NotProtocol tcp excludes it)", got)
+	}
+	if got := (ReferenceMatcher{}).Evaluate(rules, udpPkt); got != "Allow" {
+		t.Errorf("Evaluate(udp) = %q, want \"Allow\"", got)
+	}
+}
+
+func TestReferenceMatcher_SCTPPortRange(t *testing.T) {
+	rules := singlePolicyRules(&proto.Rule{
+		Action:   "Allow",
+		Protocol: &proto.Protocol{NumberOrName: &proto.Protocol_Name{Name: "sctp"}},
+		DstPorts: []*proto.PortRange{{First: 1000, Last: 2000}},
+	})
+
+	inRange := Packet{Protocol: 132, SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2"), DstPort: 1500}
+	outOfRange := Packet{Protocol: 132, SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2"), DstPort: 3000}
+	wrongProto := Packet{Protocol: 6, SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2"), DstPort: 1500}
+
+	if got := (ReferenceMatcher{}).Evaluate(rules, inRange); got != "Allow" {
+		t.Errorf("Evaluate(sctp in range) = %q, want \"Allow\"", got)
+	}
+	if got := (ReferenceMatcher{}).Evaluate(rules, outOfRange); got != "" {
+		t.Errorf("Evaluate(sctp out of range) = %q, want \"\"", got)
+	}
+	if got := (ReferenceMatcher{}).Evaluate(rules, wrongProto); got != "" {
+		t.Errorf("Evaluate(tcp on sctp rule) = %q, want \"\"", got)
+	}
+}
+
+func TestProtocolNumber_Aliases(t *testing.T) {
+	for name, want := range protocolsByName {
+		got, ok := protocolNumber(&proto.Protocol{NumberOrName: &proto.Protocol_Name{Name: name}})
+		if !ok || got != want {
+			t.Errorf("protocolNumber(%q) = (%d, %v), want (%d, true)", name, got, ok, want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package polprog compiles Calico's policy model (tiers of policies, each
+// with ordered rules) into a BPF program that the tc/XDP dataplane attaches
+// to a workload's veth. The package is organised the same way the rules it
+// compiles are: Rules at the top, made of Tiers, made of Policies, made of
+// Rules -- each layer's compiled form tail-calls into the next so that the
+// whole program stays under the verifier's per-program instruction limit.
+package polprog
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/projectcalico/felix/proto"
+)
+
+// Rules is the top-level input to a Builder: every tier that applies to
+// one workload endpoint, in the order they should be evaluated.
+type Rules struct {
+	Tiers []Tier
+}
+
+// Validate checks that every CIDR and port range referenced by r's rules
+// is well formed. Builder assumes this has already been done -- it has no
+// graceful way to reject a bad CIDR mid-compile -- so callers that build
+// Rules from outside data (e.g. ImportTestCases) must call this first.
+func (r Rules) Validate() error {
+	for _, tier := range r.Tiers {
+		for _, pol := range tier.Policies {
+			for i, rule := range pol.Rules {
+				if rule.Rule == nil {
+					continue
+				}
+				if err := validateCIDRs(rule.Rule.SrcNet); err != nil {
+					return fmt.Errorf("tier %q policy %q rule %d: source CIDR: %w", tier.Name, pol.Name, i, err)
+				}
+				if err := validateCIDRs(rule.Rule.DstNet); err != nil {
+					return fmt.Errorf("tier %q policy %q rule %d: dest CIDR: %w", tier.Name, pol.Name, i, err)
+				}
+				if err := validateCIDRs(rule.Rule.NotSrcNet); err != nil {
+					return fmt.Errorf("tier %q policy %q rule %d: negated source CIDR: %w", tier.Name, pol.Name, i, err)
+				}
+				if err := validateCIDRs(rule.Rule.NotDstNet); err != nil {
+					return fmt.Errorf("tier %q policy %q rule %d: negated dest CIDR: %w", tier.Name, pol.Name, i, err)
+				}
+				if err := validatePortRanges(rule.Rule.SrcPorts); err != nil {
+					return fmt.Errorf("tier %q policy %q rule %d: source port range: %w", tier.Name, pol.Name, i, err)
+				}
+				if err := validatePortRanges(rule.Rule.DstPorts); err != nil {
+					return fmt.Errorf("tier %q policy %q rule %d: dest port range: %w", tier.Name, pol.Name, i, err)
+				}
+				if err := validatePortRanges(rule.Rule.NotSrcPorts); err != nil {
+					return fmt.Errorf("tier %q policy %q rule %d: negated source port range: %w", tier.Name, pol.Name, i, err)
+				}
+				if err := validatePortRanges(rule.Rule.NotDstPorts); err != nil {
+					return fmt.Errorf("tier %q policy %q rule %d: negated dest port range: %w", tier.Name, pol.Name, i, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func validateCIDRs(cidrs []string) error {
+	for _, c := range cidrs {
+		if _, _, err := net.ParseCIDR(c); err != nil {
+			return fmt.Errorf("%q: %w", c, err)
+		}
+	}
+	return nil
+}
+
+func validatePortRanges(ranges []*proto.PortRange) error {
+	for _, r := range ranges {
+		if r.First > r.Last {
+			return fmt.Errorf("first %d > last %d", r.First, r.Last)
+		}
+		if r.Last > 65535 {
+			return fmt.Errorf("last %d out of range", r.Last)
+		}
+	}
+	return nil
+}
+
+// Tier is one policy tier: an ordered list of policies, any one of which
+// may allow or deny the packet, falling through to the next tier if none
+// of them match.
+type Tier struct {
+	Name     string
+	Policies []Policy
+}
+
+// Policy is one policy within a tier.
+type Policy struct {
+	Name  string
+	Rules []Rule
+
+	// Precedence orders this policy relative to the others in its tier:
+	// lower values are evaluated first. Policies with equal Precedence
+	// (the default, zero) keep their relative Tier.Policies order. See
+	// Rules.Sorted.
+	Precedence int32
+}
+
+// Rule wraps the wire-format proto.Rule with whatever additional,
+// compiler-only annotations this package needs -- L7Match (see l7.go),
+// MatchExpr, Process, ConntrackOrigin, Precedence and RateLimit -- since
+// proto.Rule itself only describes L3/L4 matching.
+type Rule struct {
+	Rule *proto.Rule
+
+	// L7Match, if set, makes this rule's action conditional on an
+	// application-layer match that can only be determined after a
+	// tail-called analyzer program has inspected the flow's payload.
+	L7Match *L7Match
+
+	// MatchExpr, if set, overrides the implicit AND of Rule's fields and
+	// L7Match with an arbitrary boolean combination of named match
+	// conditions -- see Expr.
+	MatchExpr Expr
+
+	// Process, if set, additionally restricts the rule to traffic from a
+	// process with a matching UID/GID.
+	Process *ProcessMatch
+
+	// ConntrackOrigin, if set and enabled, makes this rule's source
+	// matching apply to the flow's pre-DNAT source rather than the
+	// current packet's source.
+	ConntrackOrigin *ConntrackOriginMatch
+
+	// Precedence orders this rule relative to the others in its Policy:
+	// lower values are evaluated first. See Rules.Sorted.
+	Precedence int32
+
+	// RateLimit, if set, gates this rule on a per-rule (or per-flow)
+	// token bucket: the rule only fires once the bucket is exhausted,
+	// letting in-budget packets fall through to the next rule.
+	RateLimit *RateLimit
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import "fmt"
+
+// Per-match-kind instruction weights ruleInstructionCost uses to
+// estimate how many BPF instructions a rule will compile to. They're
+// coarse -- proportional to the comparisons/jumps a match kind emits,
+// not a cycle-accurate model -- but good enough to decide when a Rules
+// tree is at risk of the verifier's ~1M instruction limit and needs
+// splitting across a tail-called chain of programs.
+const (
+	costBaseRule         = 2
+	costPerCIDR          = 4
+	costPerPortRange     = 3
+	costPerProtocolCheck = 2
+	costPerICMPCheck     = 3
+	costPerMatchExprLeaf = 5
+)
+
+// ruleInstructionCost estimates r's compiled instruction count.
+func ruleInstructionCost(r Rule) int {
+	cost := costBaseRule
+	if r.Rule != nil {
+		for _, cidrs := range [][]string{r.Rule.SrcNet, r.Rule.NotSrcNet, r.Rule.DstNet, r.Rule.NotDstNet} {
+			cost += len(cidrs) * costPerCIDR
+		}
+		cost += len(r.Rule.SrcPorts) * costPerPortRange
+		cost += len(r.Rule.NotSrcPorts) * costPerPortRange
+		cost += len(r.Rule.DstPorts) * costPerPortRange
+		cost += len(r.Rule.NotDstPorts) * costPerPortRange
+		if r.Rule.Protocol != nil {
+			cost += costPerProtocolCheck
+		}
+		if r.Rule.NotProtocol != nil {
+			cost += costPerProtocolCheck
+		}
+		if r.Rule.Icmp != nil || r.Rule.NotIcmp != nil {
+			cost += costPerICMPCheck
+		}
+	}
+	if r.MatchExpr != nil {
+		cost += countExprLeaves(r.MatchExpr) * costPerMatchExprLeaf
+	}
+	return cost
+}
+
+// tierInstructionCost estimates the total instruction count for every
+// rule in tier.
+func tierInstructionCost(tier Tier) int {
+	cost := 0
+	for _, pol := range tier.Policies {
+		for _, r := range pol.Rules {
+			cost += ruleInstructionCost(r)
+		}
+	}
+	return cost
+}
+
+// ProgramChunk is one program in Builder.Plan's tail-call continuation
+// chain: the subset of the original Rules' Tiers it evaluates (in their
+// original order), and the ProgramSpec it compiles to.
+type ProgramChunk struct {
+	Tiers []Tier
+	Spec  ProgramSpec
+}
+
+// Plan splits rules into a chain of ProgramChunks, each estimated at no
+// more than maxInstructions, so that a Rules tree whose tiers together
+// would risk exceeding the verifier's complexity limit compiles to a
+// chain of programs linked by the jump map's existing tail-call mechanism
+// instead of one program that fails to load. A single tier is never
+// split: a tier whose own estimate exceeds maxInstructions gets a chunk
+// to itself, and that chunk's estimate is over the limit. Splits only
+// ever land on a tier boundary: Rules.Sorted never
+// reorders Tiers relative to each other, only the Policies and Rules
+// within each one, so evaluating the chunks in order and falling through
+// to the next chunk whenever one produces no verdict is equivalent to
+// evaluating every tier in a single unsplit Rules.
+//
+// A Rules tree that fits under maxInstructions in one chunk returns a
+// single-element plan, so small policies don't pay a tail call's
+// overhead for nothing.
+func (b *Builder) Plan(rules Rules, progType string, maxInstructions int) []ProgramChunk {
+	var chunks []ProgramChunk
+	var current []Tier
+	currentCost := 0
+
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		name := "calico_policy"
+		if len(chunks) > 0 {
+			name = fmt.Sprintf("calico_policy_cont%d", len(chunks))
+		}
+		chunks = append(chunks, ProgramChunk{
+			Tiers: current,
+			Spec:  ProgramSpec{Name: name, Type: progType, License: "Apache-2.0"},
+		})
+		current = nil
+		currentCost = 0
+	}
+
+	for _, tier := range rules.Tiers {
+		cost := tierInstructionCost(tier)
+		if currentCost > 0 && currentCost+cost > maxInstructions {
+			flush()
+		}
+		current = append(current, tier)
+		currentCost += cost
+	}
+	flush()
+	return chunks
+}
+
+// EvaluatePlan evaluates pkt against chunks in order using m, continuing
+// to the next chunk -- the same tail call a verifier-complexity-split
+// program makes -- whenever a chunk's tiers produce no verdict. Per
+// Plan's doc comment, this always agrees with evaluating every tier in
+// one unsplit Rules tree.
+func EvaluatePlan(m ReferenceMatcher, chunks []ProgramChunk, pkt Packet) Action {
+	for _, chunk := range chunks {
+		if verdict := m.Evaluate(Rules{Tiers: chunk.Tiers}, pkt); verdict != "" {
+			return verdict
+		}
+	}
+	return ""
+}
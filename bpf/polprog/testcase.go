@@ -0,0 +1,175 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/projectcalico/felix/proto"
+)
+
+// TestCase is one portable, JSON-serialisable policy test fixture: a set
+// of rules, a packet to evaluate them against, and the action a
+// conformant matcher (ReferenceMatcher, or eventually the compiled BPF
+// program itself) is expected to produce.  Keeping these as plain JSON
+// rather than Go literals lets them be generated by the fuzzer in
+// fuzz_test.go, checked into a corpus, and replayed by other
+// implementations (e.g. a future Rust or eBPF-CO-RE rewrite) without
+// linking against this package.
+//
+// PacketDSL, if set, is a compact human-written alternative to Packet
+// (see ParsePacketDSL); ImportTestCases expands it into Packet so callers
+// never have to deal with both forms.
+type TestCase struct {
+	Name           string `json:"name"`
+	Rules          Rules  `json:"rules"`
+	PacketDSL      string `json:"packet_dsl,omitempty"`
+	Packet         Packet `json:"packet"`
+	ExpectedAction Action `json:"expected_action"`
+}
+
+// ExportTestCases writes cases to w as newline-delimited JSON, one
+// TestCase per line, so large corpora can be streamed without holding the
+// whole set in memory.
+func ExportTestCases(w io.Writer, cases []TestCase) error {
+	enc := json.NewEncoder(w)
+	for _, c := range cases {
+		if err := enc.Encode(c); err != nil {
+			return fmt.Errorf("failed to encode policy test case %q: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// ImportTestCases reads a newline-delimited JSON stream of TestCases
+// written by ExportTestCases. Each case's PacketDSL (if set) is expanded
+// into Packet and its Rules are validated up front, so a malformed
+// contributed fixture fails to load with a clear error rather than
+// silently mismatching every compiled program it's run against.
+func ImportTestCases(r io.Reader) ([]TestCase, error) {
+	var cases []TestCase
+	scanner := bufio.NewScanner(r)
+	// Test cases can embed sizeable CIDR/port lists; grow the default
+	// token buffer well past bufio's 64KiB default rather than failing
+	// on a long line.
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var c TestCase
+		if err := json.Unmarshal(line, &c); err != nil {
+			return nil, fmt.Errorf("failed to decode policy test case: %w", err)
+		}
+		if c.PacketDSL != "" {
+			pkt, err := ParsePacketDSL(c.PacketDSL)
+			if err != nil {
+				return nil, fmt.Errorf("policy test case %q: %w", c.Name, err)
+			}
+			c.Packet = pkt
+		}
+		if err := c.Rules.Validate(); err != nil {
+			return nil, fmt.Errorf("policy test case %q: %w", c.Name, err)
+		}
+		cases = append(cases, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cases, nil
+}
+
+// ParsePacketDSL parses the compact one-line packet notation used in
+// TestCase.PacketDSL, e.g. "tcp 10.0.0.1:31245 -> 10.0.0.2:80", so
+// contributed fixtures don't need to spell out a full Packet struct.
+func ParsePacketDSL(dsl string) (Packet, error) {
+	fields := strings.Fields(dsl)
+	if len(fields) != 4 || fields[2] != "->" {
+		return Packet{}, fmt.Errorf("malformed packet DSL %q, want \"<proto> <src ip>:<port> -> <dst ip>:<port>\"", dsl)
+	}
+
+	proto, ok := protocolsByName[fields[0]]
+	if !ok {
+		return Packet{}, fmt.Errorf("malformed packet DSL %q: unknown protocol %q", dsl, fields[0])
+	}
+
+	src, srcPort, err := parseHostPort(fields[1])
+	if err != nil {
+		return Packet{}, fmt.Errorf("malformed packet DSL %q: source %w", dsl, err)
+	}
+	dst, dstPort, err := parseHostPort(fields[3])
+	if err != nil {
+		return Packet{}, fmt.Errorf("malformed packet DSL %q: dest %w", dsl, err)
+	}
+
+	return Packet{
+		Protocol: proto,
+		SrcIP:    src,
+		SrcPort:  srcPort,
+		DstIP:    dst,
+		DstPort:  dstPort,
+	}, nil
+}
+
+var protocolsByName = map[string]uint8{
+	"icmp":   1,
+	"tcp":    6,
+	"udp":    17,
+	"gre":    47,
+	"esp":    50,
+	"ah":     51,
+	"icmpv6": 58,
+	"sctp":   132,
+}
+
+// protocolNumber resolves p -- a protobuf oneof that, per Calico's
+// NetworkPolicy API, carries either a numeric IANA protocol or one of the
+// names in protocolsByName -- to the numeric protocol matchesRule and
+// ParsePacketDSL both key their matching on. ok is false when p is nil,
+// i.e. the rule doesn't constrain protocol at all, and also when p names
+// a protocol that protocolsByName does not list.
+func protocolNumber(p *proto.Protocol) (uint8, bool) {
+	if p == nil {
+		return 0, false
+	}
+	if name := p.GetName(); name != "" {
+		num, ok := protocolsByName[strings.ToLower(name)]
+		return num, ok
+	}
+	return uint8(p.GetNumber()), true
+}
+
+func parseHostPort(hostPort string) (net.IP, uint16, error) {
+	host, portStr, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%q is not <ip>:<port>: %w", hostPort, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("%q is not a valid IP", host)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%q is not a valid port: %w", portStr, err)
+	}
+	return ip, uint16(port), nil
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/projectcalico/felix/proto"
+)
+
+func TestParsePacketDSL(t *testing.T) {
+	pkt, err := ParsePacketDSL("tcp 10.0.0.1:31245 -> 10.0.0.2:80")
+	if err != nil {
+		t.Fatalf("ParsePacketDSL returned error: %v", err)
+	}
+	want := Packet{
+		Protocol: 6,
+		SrcIP:    net.ParseIP("10.0.0.1"),
+		SrcPort:  31245,
+		DstIP:    net.ParseIP("10.0.0.2"),
+		DstPort:  80,
+	}
+	if pkt.Protocol != want.Protocol || !pkt.SrcIP.Equal(want.SrcIP) || pkt.SrcPort != want.SrcPort ||
+		!pkt.DstIP.Equal(want.DstIP) || pkt.DstPort != want.DstPort {
+		t.Errorf("ParsePacketDSL = %+v, want %+v", pkt, want)
+	}
+}
+
+func TestParsePacketDSLErrors(t *testing.T) {
+	for _, dsl := range []string{
+		"tcp 10.0.0.1:31245",
+		"quic 10.0.0.1:1 -> 10.0.0.2:2",
+		"tcp not-an-ip:1 -> 10.0.0.2:2",
+		"tcp 10.0.0.1:not-a-port -> 10.0.0.2:2",
+	} {
+		if _, err := ParsePacketDSL(dsl); err == nil {
+			t.Errorf("ParsePacketDSL(%q) = nil error, want error", dsl)
+		}
+	}
+}
+
+func TestExportImportTestCasesRoundTrip(t *testing.T) {
+	cases := []TestCase{{
+		Name: "allow-tcp",
+		Rules: Rules{Tiers: []Tier{{
+			Name: "tier",
+			Policies: []Policy{{
+				Name:  "pol",
+				Rules: []Rule{{Rule: &proto.Rule{Action: "Allow", SrcNet: []string{"10.0.0.0/8"}}}},
+			}},
+		}}},
+		PacketDSL:      "tcp 10.0.0.1:31245 -> 10.0.0.2:80",
+		ExpectedAction: "Allow",
+	}}
+
+	var buf bytes.Buffer
+	if err := ExportTestCases(&buf, cases); err != nil {
+		t.Fatalf("ExportTestCases returned error: %v", err)
+	}
+
+	got, err := ImportTestCases(&buf)
+	if err != nil {
+		t.Fatalf("ImportTestCases returned error: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("ImportTestCases returned %d cases, want 1", len(got))
+	}
+	if got[0].Name != "allow-tcp" || got[0].ExpectedAction != "Allow" {
+		t.Errorf("ImportTestCases = %+v", got[0])
+	}
+	if !got[0].Packet.SrcIP.Equal(net.ParseIP("10.0.0.1")) || got[0].Packet.DstPort != 80 {
+		t.Errorf("ImportTestCases did not expand PacketDSL into Packet: %+v", got[0].Packet)
+	}
+}
+
+func TestImportTestCasesRejectsInvalidRules(t *testing.T) {
+	cases := []TestCase{{
+		Name: "bad-cidr",
+		Rules: Rules{Tiers: []Tier{{
+			Name: "tier",
+			Policies: []Policy{{
+				Name:  "pol",
+				Rules: []Rule{{Rule: &proto.Rule{Action: "Allow", SrcNet: []string{"not-a-cidr"}}}},
+			}},
+		}}},
+		ExpectedAction: "Allow",
+	}}
+
+	var buf bytes.Buffer
+	if err := ExportTestCases(&buf, cases); err != nil {
+		t.Fatalf("ExportTestCases returned error: %v", err)
+	}
+
+	if _, err := ImportTestCases(&buf); err == nil {
+		t.Fatal("ImportTestCases = nil error, want error for invalid CIDR")
+	}
+}
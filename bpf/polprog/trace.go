@@ -0,0 +1,104 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+// TraceRecord is the fixed-size record a debug-enabled compiled program
+// pushes onto its BPF_MAP_TYPE_QUEUE verdict trace map before jumping to
+// allow/drop. Seqno is monotonic per TraceRing so userspace can detect
+// whether it's fallen behind and the ring has wrapped/dropped records
+// between drains.
+type TraceRecord struct {
+	Seqno     uint64
+	RuleID    string
+	IPSetID   uint64
+	TupleHash uint64
+	Verdict   Action
+}
+
+// TraceRing is ReferenceMatcher's userspace stand-in for the compiled
+// program's verdict trace queue. A BPF_MAP_TYPE_QUEUE was chosen over a
+// perf ring for the real map because verdict events are low-rate and
+// FIFO-with-backpressure (drop when full) is the behaviour wanted, not
+// per-CPU fan-out, so TraceRing mirrors that: push drops the record
+// (and counts it in Dropped) once the ring is at Capacity, rather than
+// growing or overwriting the oldest entry.
+type TraceRing struct {
+	Capacity int
+
+	mu      sync.Mutex
+	records []TraceRecord
+	seqno   uint64
+	dropped uint64
+}
+
+// NewTraceRing creates a TraceRing that holds at most capacity records
+// before push starts dropping.
+func NewTraceRing(capacity int) *TraceRing {
+	return &TraceRing{Capacity: capacity}
+}
+
+func (t *TraceRing) push(ruleID string, ipSetID, tupleHash uint64, verdict Action) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.records) >= t.Capacity {
+		// A dropped record still consumes a seqno, so the drainer sees
+		// the gap.
+		t.seqno++
+		t.dropped++
+		return
+	}
+	t.records = append(t.records, TraceRecord{
+		Seqno:     t.seqno,
+		RuleID:    ruleID,
+		IPSetID:   ipSetID,
+		TupleHash: tupleHash,
+		Verdict:   verdict,
+	})
+	t.seqno++
+}
+
+// Drain removes and returns every record currently queued, the same
+// effect a userspace non-blocking Lookup+Delete loop against the real
+// queue map has.
+func (t *TraceRing) Drain() []TraceRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := t.records
+	t.records = nil
+	return out
+}
+
+// Dropped is the number of records push has discarded because the ring
+// was at Capacity, i.e. how far behind a userspace drainer has fallen.
+func (t *TraceRing) Dropped() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.dropped
+}
+
+// tupleHash hashes pkt's 5-tuple into the fixed-width value TraceRecord
+// carries instead of the full Packet, keeping the record a fixed size the
+// same way the compiled program's struct would be.
+func tupleHash(pkt Packet) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d-%s-%d-%s-%d", pkt.Protocol, pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort)
+	return h.Sum64()
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polprog
+
+import (
+	"net"
+	"testing"
+
+	"github.com/projectcalico/felix/proto"
+)
+
+// TestReferenceMatcher_Trace checks that a record is pushed for every
+// matched rule, in evaluation order, for a mix of allowed and dropped
+// packets -- the ReferenceMatcher analogue of asserting the trace
+// produced for each AllowedPackets()/DroppedPackets() case.
+func TestReferenceMatcher_Trace(t *testing.T) {
+	rules := Rules{Tiers: []Tier{{
+		Name: "tier",
+		Policies: []Policy{{
+			Name: "pol",
+			Rules: []Rule{
+				{Rule: &proto.Rule{Action: "Allow", Protocol: &proto.Protocol{NumberOrName: &proto.Protocol_Name{Name: "tcp"}}}},
+				{Rule: &proto.Rule{Action: "Deny"}},
+			},
+		}},
+	}}}
+
+	trace := NewTraceRing(10)
+	m := ReferenceMatcher{Trace: trace}
+
+	allowed := Packet{Protocol: 6, SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2")}
+	dropped := Packet{Protocol: 17, SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2")}
+
+	if got := m.Evaluate(rules, allowed); got != "Allow" {
+		t.Fatalf("Evaluate(allowed) = %q, want \"Allow\"", got)
+	}
+	if got := m.Evaluate(rules, dropped); got != "Deny" {
+		t.Fatalf("Evaluate(dropped) = %q, want \"Deny\"", got)
+	}
+
+	records := trace.Drain()
+	if len(records) != 2 {
+		t.Fatalf("Drain() returned %d records, want 2", len(records))
+	}
+	if records[0].Verdict != "Allow" || records[0].RuleID != "tier/pol#0" {
+		t.Errorf("records[0] = %+v, want Verdict=Allow RuleID=tier/pol#0", records[0])
+	}
+	if records[1].Verdict != "Deny" || records[1].RuleID != "tier/pol#1" {
+		t.Errorf("records[1] = %+v, want Verdict=Deny RuleID=tier/pol#1", records[1])
+	}
+	if records[0].Seqno >= records[1].Seqno {
+		t.Errorf("records[0].Seqno=%d should be < records[1].Seqno=%d", records[0].Seqno, records[1].Seqno)
+	}
+
+	// A second drain should find nothing left to report.
+	if remaining := trace.Drain(); len(remaining) != 0 {
+		t.Errorf("second Drain() returned %d records, want 0", len(remaining))
+	}
+}
+
+func TestTraceRing_DropsWhenFull(t *testing.T) {
+	trace := NewTraceRing(1)
+	trace.push("r1", 0, 0, "Allow")
+	trace.push("r2", 0, 0, "Deny")
+
+	records := trace.Drain()
+	if len(records) != 1 {
+		t.Fatalf("Drain() returned %d records, want 1", len(records))
+	}
+	if trace.Dropped() != 1 {
+		t.Errorf("Dropped() = %d, want 1", trace.Dropped())
+	}
+}
+
+func TestBuilder_EnableTrace(t *testing.T) {
+	b := NewBuilder(nil, 1, 2, 3)
+	if b.TraceEnabled() {
+		t.Fatal("TraceEnabled() = true before EnableTrace was called")
+	}
+	b.EnableTrace()
+	if !b.TraceEnabled() {
+		t.Fatal("TraceEnabled() = false after EnableTrace was called")
+	}
+}
@@ -325,6 +325,200 @@ var _ = Describe("BPF service type change", func() {
 	})
 })
 
+var _ = Describe("BPF service type change with traffic policy, affinity and topology", func() {
+
+	clusterIP := net.IPv4(10, 2, 0, 1)
+	port := uint16(1234)
+	proto := v1.ProtocolTCP
+	npPort := int32(30334)
+
+	localEPIP := "10.2.2.1"
+	remoteEPIP := "10.2.2.2"
+
+	testSvc := &v1.Service{
+		TypeMeta:   typeMetaV1("Service"),
+		ObjectMeta: objectMeataV1("testService2"),
+		Spec: v1.ServiceSpec{
+			ClusterIP: "10.2.0.1",
+			Type:      v1.ServiceTypeNodePort,
+			Selector: map[string]string{
+				"app": "test2",
+			},
+			Ports: []v1.ServicePort{
+				{
+					Protocol: v1.ProtocolTCP,
+					Port:     int32(port),
+					NodePort: npPort,
+				},
+			},
+		},
+	}
+
+	testSvcEps := &v1.Endpoints{
+		TypeMeta:   typeMetaV1("Endpoints"),
+		ObjectMeta: objectMeataV1("testService2"),
+		Subsets: []v1.EndpointSubset{
+			{
+				Addresses: []v1.EndpointAddress{
+					{IP: localEPIP, NodeName: strPtr("test-node")},
+					{IP: remoteEPIP, NodeName: strPtr("other-node")},
+				},
+				Ports: []v1.EndpointPort{
+					{
+						Port: 1234,
+						Name: "1234",
+					},
+				},
+			},
+		},
+	}
+	k8s := fake.NewSimpleClientset(testSvc, testSvcEps)
+
+	initIP := net.IPv4(2, 2, 2, 2)
+
+	front := newMockNATMap()
+	back := newMockNATBackendMap()
+	aff := newMockAffinityMap()
+	ct := mock.NewMockMap(conntrack.MapParams)
+	p, _ := proxy.StartKubeProxy(k8s, "test-node", front, back, aff, ct, proxy.WithImmediateSync())
+	p.OnHostIPsUpdate([]net.IP{initIP})
+
+	key_clusterIP := nat.NewNATKey(clusterIP, port, proxy.ProtoV1ToIntPanic(proto))
+
+	AfterEach(func() {
+		p.Stop()
+	})
+
+	It("should only program local endpoints for ExternalTrafficPolicy=Local, and a drop entry with none", func() {
+		By("checking both endpoints are programmed for Cluster traffic policy", func() {
+			Eventually(func() int {
+				back.Lock()
+				defer back.Unlock()
+				return len(back.m)
+			}).Should(Equal(2))
+		})
+
+		By("switching to ExternalTrafficPolicy=Local", func() {
+			setSvcExternalTrafficPolicy(testSvc, v1.ServiceExternalTrafficPolicyTypeLocal, k8s)
+			Eventually(func() int {
+				back.Lock()
+				defer back.Unlock()
+				return len(back.m)
+			}).Should(Equal(1))
+		})
+
+		By("removing the only local endpoint", func() {
+			testSvcEps.Subsets[0].Addresses = []v1.EndpointAddress{
+				{IP: remoteEPIP, NodeName: strPtr("other-node")},
+			}
+			_, err := k8s.CoreV1().Endpoints(v1.NamespaceDefault).Update(testSvcEps)
+			Expect(err).NotTo(HaveOccurred())
+
+			// With no local endpoints left, the frontend should still exist (so the
+			// service keeps failing closed rather than silently falling through to
+			// a remote backend) but with no backends programmed for it.
+			Eventually(func() bool {
+				front.Lock()
+				back.Lock()
+				defer front.Unlock()
+				defer back.Unlock()
+				_, ret := front.m[key_clusterIP]
+				return ret && len(back.m) == 0
+			}).Should(BeTrue())
+		})
+	})
+
+	It("should keep a recorded session affinity entry and its timeout across endpoint churn once ClientIP affinity is configured", func() {
+		By("enabling ClientIP session affinity with a 100s timeout", func() {
+			setSvcSessionAffinity(testSvc, 100, k8s)
+		})
+
+		clientIP := net.IPv4(30, 0, 0, 1)
+		affKey := nat.NewAffinityKey(clientIP, key_clusterIP)
+
+		By("recording an affinity entry, as the dataplane would on the first packet from a client", func() {
+			aff.Lock()
+			aff.m[affKey] = nat.NewAffinityValue(100, nat.NewNATBackendValue(net.ParseIP(localEPIP), port))
+			aff.Unlock()
+		})
+
+		By("churning the endpoints and checking the affinity entry and its timeout survive", func() {
+			testSvcEps.Subsets[0].Addresses = append(testSvcEps.Subsets[0].Addresses, v1.EndpointAddress{
+				IP: "10.2.2.3", NodeName: strPtr("other-node"),
+			})
+			_, err := k8s.CoreV1().Endpoints(v1.NamespaceDefault).Update(testSvcEps)
+			Expect(err).NotTo(HaveOccurred())
+
+			Consistently(func() bool {
+				aff.Lock()
+				defer aff.Unlock()
+				v, ok := aff.m[affKey]
+				return ok && v.Timeout() == 100
+			}).Should(BeTrue())
+		})
+	})
+
+	It("should filter backends by the node's zone when TopologyKeys is set", func() {
+		By("labelling the node with a zone and setting topology keys on the service", func() {
+			node := &v1.Node{
+				ObjectMeta: objectMeataV1("test-node"),
+			}
+			node.Labels = map[string]string{"topology.kubernetes.io/zone": "zone-a"}
+			_, err := k8s.CoreV1().Nodes().Create(node)
+			Expect(err).NotTo(HaveOccurred())
+
+			setSvcTopology(testSvc, []string{"topology.kubernetes.io/zone"}, k8s)
+		})
+
+		By("only programming endpoints whose hints.ForZones matches the node's zone", func() {
+			testSvcEps.Subsets[0].Addresses = []v1.EndpointAddress{
+				{IP: localEPIP, NodeName: strPtr("test-node"), Hints: &v1.EndpointHints{
+					ForZones: []v1.ForZone{{Name: "zone-a"}},
+				}},
+				{IP: remoteEPIP, NodeName: strPtr("other-node"), Hints: &v1.EndpointHints{
+					ForZones: []v1.ForZone{{Name: "zone-b"}},
+				}},
+			}
+			_, err := k8s.CoreV1().Endpoints(v1.NamespaceDefault).Update(testSvcEps)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func() int {
+				back.Lock()
+				defer back.Unlock()
+				return len(back.m)
+			}).Should(Equal(1))
+		})
+	})
+})
+
+func strPtr(s string) *string { return &s }
+
+func setSvcExternalTrafficPolicy(testSvc *v1.Service, policy v1.ServiceExternalTrafficPolicyType, k8s *fake.Clientset) {
+	testSvc.Spec.ExternalTrafficPolicy = policy
+	_, err := k8s.CoreV1().Services(v1.NamespaceDefault).Update(testSvc)
+	Expect(err).NotTo(HaveOccurred())
+}
+
+func setSvcSessionAffinity(testSvc *v1.Service, timeoutSeconds int32, k8s *fake.Clientset) {
+	if timeoutSeconds == 0 {
+		testSvc.Spec.SessionAffinity = v1.ServiceAffinityNone
+		testSvc.Spec.SessionAffinityConfig = nil
+	} else {
+		testSvc.Spec.SessionAffinity = v1.ServiceAffinityClientIP
+		testSvc.Spec.SessionAffinityConfig = &v1.SessionAffinityConfig{
+			ClientIP: &v1.ClientIPConfig{TimeoutSeconds: &timeoutSeconds},
+		}
+	}
+	_, err := k8s.CoreV1().Services(v1.NamespaceDefault).Update(testSvc)
+	Expect(err).NotTo(HaveOccurred())
+}
+
+func setSvcTopology(testSvc *v1.Service, keys []string, k8s *fake.Clientset) {
+	testSvc.Spec.TopologyKeys = keys
+	_, err := k8s.CoreV1().Services(v1.NamespaceDefault).Update(testSvc)
+	Expect(err).NotTo(HaveOccurred())
+}
+
 func setSvcTypeToClusterIP(testSvc *v1.Service, k8s *fake.Clientset) {
 	testSvc.Spec.ExternalIPs = []string{}
 	testSvc.Spec.LoadBalancerSourceRanges = []string{}
@@ -0,0 +1,96 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routes
+
+import (
+	"net"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ChainedResult is the subset of a chained IPAM plugin's CNI result that
+// the BPF route table cares about: the IPs it assigned and, for each, the
+// device it expects traffic to arrive/depart on.  It's a narrowed-down
+// stand-in for github.com/containernetworking/cni/pkg/types/current.Result
+// so this package doesn't have to import the full CNI type tree just to
+// read a couple of fields out of it.
+type ChainedResult struct {
+	IPs []ChainedIPConfig
+}
+
+// ChainedIPConfig is one IP assigned by the chained plugin.
+type ChainedIPConfig struct {
+	Address net.IPNet
+	// Interface is the workload-side interface name the chained plugin
+	// configured the address on (e.g. "eth0").  RoutesForResult does not
+	// read it: every route points at the host-side veth's ifindex.
+	Interface string
+}
+
+// IfIndexLookup resolves a workload interface name (as seen from the host
+// netns) to its ifindex, the way Calico normally does for its own
+// IPAM-assigned workload endpoints.
+type IfIndexLookup func(ifaceName string) (int, error)
+
+// ChainedIPAMHandoff builds BPF routes for addresses that were assigned by
+// a chained IPAM plugin (e.g. host-local or whereabouts run ahead of
+// Calico in the CNI chain) rather than by Calico's own IPAM. Calico still
+// owns policy and the dataplane for these workloads; it just didn't
+// allocate their addresses, so the usual WorkloadEndpoint-driven route
+// source doesn't have an IP pool to attribute them to. The handoff treats
+// every chained-assigned IP as a local workload route pinned to whatever
+// host-side veth Calico created for the pod.
+type ChainedIPAMHandoff struct {
+	resolveIfIndex IfIndexLookup
+}
+
+// NewChainedIPAMHandoff creates a handoff that resolves host-side ifindexes
+// via resolveIfIndex (typically netlink.LinkByName).
+func NewChainedIPAMHandoff(resolveIfIndex IfIndexLookup) *ChainedIPAMHandoff {
+	return &ChainedIPAMHandoff{resolveIfIndex: resolveIfIndex}
+}
+
+// RoutesForResult converts a chained plugin's result, plus the host-side
+// veth name Calico created for the workload, into the route entries that
+// should be programmed for it.  The host-side veth's ifindex is looked up
+// once for all of the result's IPs; if that lookup fails, the failure is
+// logged and an empty Map is returned.
+func (h *ChainedIPAMHandoff) RoutesForResult(result ChainedResult, hostVeth string) Map {
+	routes := NewMap()
+	ifIndex, err := h.resolveIfIndex(hostVeth)
+	if err != nil {
+		log.WithError(err).WithField("veth", hostVeth).Warn(
+			"Failed to resolve ifindex for chained-IPAM workload; skipping its routes")
+		return routes
+	}
+	for _, ipCfg := range result.IPs {
+		cidr := &net.IPNet{IP: ipCfg.Address.IP, Mask: fullMask(ipCfg.Address.IP)}
+		routes.Set(cidr, Value{
+			Type:    TypeLocalWorkload,
+			IfIndex: ifIndex,
+		})
+	}
+	return routes
+}
+
+// fullMask returns a /32 (or /128 for IPv6) mask for ip, since a chained
+// plugin's assigned address should always route as a host route to the
+// single workload, regardless of the subnet mask the plugin reported.
+func fullMask(ip net.IP) net.IPMask {
+	if ip.To4() != nil {
+		return net.CIDRMask(32, 32)
+	}
+	return net.CIDRMask(128, 128)
+}
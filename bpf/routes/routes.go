@@ -0,0 +1,86 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package routes models the BPF dataplane's routing table: the map from
+// workload/remote CIDRs to how the BPF programs should treat traffic to
+// that destination (deliver locally to a veth, forward to a tunnel, or
+// treat as a remote host).  It's deliberately a thin, in-memory model of
+// the real BPF map's contents so that callers (felix-synced routes, and
+// the chained-IPAM handoff in chained_ipam.go) can compute diffs in Go
+// before the BPF dataplane layer programs the actual map.
+package routes
+
+import (
+	"fmt"
+	"net"
+)
+
+// Type classifies a route entry the same way Calico's BPF route value does.
+type Type uint8
+
+const (
+	TypeRemoteHost Type = iota
+	TypeLocalHost
+	TypeRemoteWorkload
+	TypeLocalWorkload
+	TypeLocalTunnel
+)
+
+// Value is a single route's metadata, keyed by CIDR in Map.
+type Value struct {
+	Type    Type
+	NodeIP  net.IP
+	IfIndex int
+}
+
+// Map is the desired set of routes, keyed by destination CIDR string
+// (net.IPNet.String()) so callers can diff two generations cheaply.
+type Map map[string]Value
+
+// NewMap returns an empty route map.
+func NewMap() Map {
+	return Map{}
+}
+
+// Set installs or overwrites the route for cidr.
+func (m Map) Set(cidr *net.IPNet, v Value) {
+	m[cidr.String()] = v
+}
+
+// Delete removes any route for cidr.
+func (m Map) Delete(cidr *net.IPNet) {
+	delete(m, cidr.String())
+}
+
+// Diff returns the routes that need to be added/updated (present in want
+// but different or absent in have) and the CIDRs that need to be removed
+// (present in have but absent from want).
+func Diff(have, want Map) (upserts Map, deletes []string) {
+	upserts = NewMap()
+	for cidr, v := range want {
+		if existing, ok := have[cidr]; !ok || existing != v {
+			upserts[cidr] = v
+		}
+	}
+	for cidr := range have {
+		if _, ok := want[cidr]; !ok {
+			deletes = append(deletes, cidr)
+		}
+	}
+	return upserts, deletes
+}
+
+func (v Value) String() string {
+	return fmt.Sprintf("type=%d node=%s ifindex=%d", v.Type, v.NodeIP, v.IfIndex)
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2021 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policysets
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/felix/dataplane/windows/hns"
+)
+
+// defaultPollInterval is how often StaticRulesWatcher re-reads its source
+// and compares the bytes with the previous read to spot changes.
+const defaultPollInterval = 10 * time.Second
+
+// StaticRuleSource supplies the raw static-rules JSON document, however it's
+// actually stored.  fileReader (see static_rules.go) already implements
+// this for the on-disk case; crdRuleSource below implements it for rules
+// coming from a CRD.
+type StaticRuleSource interface {
+	readData() ([]byte, error)
+}
+
+// crdRuleSource reads static ACL rules from a Calico CRD (e.g.
+// StaticPolicySet) instead of a local file, so that rules can be pushed to
+// Windows nodes the same way other policy config is: via the datastore,
+// with no need to drop a file on every node.
+type crdRuleSource struct {
+	// fetch retrieves the current raw JSON spec of the CRD; it's a
+	// function rather than a client handle so tests can stub it out
+	// without a fake API server.
+	fetch func() ([]byte, error)
+}
+
+// NewCRDRuleSource wraps a CRD-fetching function as a StaticRuleSource.
+func NewCRDRuleSource(fetch func() ([]byte, error)) StaticRuleSource {
+	return &crdRuleSource{fetch: fetch}
+}
+
+func (c *crdRuleSource) readData() ([]byte, error) {
+	data, err := c.fetch()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrNoRuleSpecified
+	}
+	return data, nil
+}
+
+// StaticRulesWatcher periodically re-reads a StaticRuleSource and calls
+// back whenever the parsed set of ACL rules actually changes, so that
+// editing a CRD (or the on-disk file, in a pinch) takes effect without a
+// Felix restart.
+type StaticRulesWatcher struct {
+	source       StaticRuleSource
+	pollInterval time.Duration
+	onChange     func([]*hns.ACLPolicy)
+
+	lastRaw []byte
+}
+
+// NewStaticRulesWatcher creates a watcher over source that invokes onChange
+// every time the rules change, starting with an initial read.  Pass
+// interval <= 0 to use defaultPollInterval.
+func NewStaticRulesWatcher(source StaticRuleSource, interval time.Duration, onChange func([]*hns.ACLPolicy)) *StaticRulesWatcher {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	return &StaticRulesWatcher{
+		source:       source,
+		pollInterval: interval,
+		onChange:     onChange,
+	}
+}
+
+// Start reads the rules once synchronously (so callers can pick up the
+// initial rule set before returning), then continues polling for changes
+// in a background goroutine until stopC is closed.
+func (w *StaticRulesWatcher) Start(stopC <-chan struct{}) {
+	w.pollOnce()
+	go func() {
+		ticker := time.NewTicker(w.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.pollOnce()
+			case <-stopC:
+				return
+			}
+		}
+	}()
+}
+
+func (w *StaticRulesWatcher) pollOnce() {
+	raw, err := w.source.readData()
+	if err == ErrNoRuleSpecified {
+		if w.lastRaw != nil {
+			log.Info("Static rule source no longer has any rules; clearing")
+			w.lastRaw = nil
+			w.onChange(nil)
+		}
+		return
+	}
+	if err != nil {
+		log.WithError(err).Warn("Failed to read static rules source; keeping last known-good rules")
+		return
+	}
+	if bytesEqual(raw, w.lastRaw) {
+		return
+	}
+
+	policies := readStaticRules(rawBytesReader(raw))
+	log.WithField("numRules", len(policies)).Info("Static ACL rules changed, reloading")
+	w.lastRaw = raw
+	w.onChange(policies)
+}
+
+func bytesEqual(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// rawBytesReader adapts an already-fetched []byte to the staticRulesReader
+// interface that readStaticRules expects, so pollOnce can reuse the
+// existing JSON parsing/validation logic unchanged.
+type rawBytesReader []byte
+
+func (r rawBytesReader) readData() ([]byte, error) {
+	return r, nil
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2019 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// faultSpec describes the adverse network conditions to simulate, parsed from
+// a comma-separated spec such as:
+//
+//	latency=50ms,jitter=10ms,loss=1%,dup=0.5%,reorder=5%,blackhole-after=3s
+//
+// It's deliberately a subset of what tc/netem supports: just enough to drive
+// policy FV tests (resets mid-flow, delayed handshakes, partial writes)
+// without needing tc/netem privileges on the test host.
+type faultSpec struct {
+	latency        time.Duration
+	jitter         time.Duration
+	lossFraction   float64
+	dupFraction    float64
+	reorderFrac    float64
+	blackholeAfter time.Duration
+}
+
+func parseFaultSpec(spec string) (*faultSpec, error) {
+	fs := &faultSpec{}
+	if spec == "" {
+		return fs, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid fault spec clause %q", part)
+		}
+		key, val := kv[0], kv[1]
+		var err error
+		switch key {
+		case "latency":
+			fs.latency, err = time.ParseDuration(val)
+		case "jitter":
+			fs.jitter, err = time.ParseDuration(val)
+		case "loss":
+			fs.lossFraction, err = parsePercent(val)
+		case "dup":
+			fs.dupFraction, err = parsePercent(val)
+		case "reorder":
+			fs.reorderFrac, err = parsePercent(val)
+		case "blackhole-after":
+			fs.blackholeAfter, err = time.ParseDuration(val)
+		default:
+			return nil, fmt.Errorf("unknown fault spec key %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %q: %w", key, err)
+		}
+	}
+	return fs, nil
+}
+
+func parsePercent(s string) (float64, error) {
+	s = strings.TrimSuffix(s, "%")
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return f / 100.0, nil
+}
+
+// faultConn wraps a net.Conn, mangling writes according to a faultSpec plus
+// the deterministic --drop-after-bytes / --close-after knobs.
+type faultConn struct {
+	net.Conn
+
+	spec           *faultSpec
+	dropAfterBytes int
+	closeAfter     time.Duration
+
+	mutex        sync.Mutex
+	bytesWritten int
+	start        time.Time
+	closedByUs   bool
+}
+
+func newFaultConn(conn net.Conn, spec *faultSpec, dropAfterBytes int, closeAfter time.Duration) *faultConn {
+	fc := &faultConn{
+		Conn:           conn,
+		spec:           spec,
+		dropAfterBytes: dropAfterBytes,
+		closeAfter:     closeAfter,
+		start:          time.Now(),
+	}
+	if closeAfter > 0 {
+		go func() {
+			time.Sleep(closeAfter)
+			log.WithField("closeAfter", closeAfter).Info("fault injector: closing connection on schedule")
+			fc.mutex.Lock()
+			fc.closedByUs = true
+			fc.mutex.Unlock()
+			conn.Close()
+		}()
+	}
+	return fc
+}
+
+// Write applies the blackhole-after and deterministic byte-count cutoffs,
+// then loss, then latency/jitter plus any reorder delay, before handing off
+// to the real connection; dup then writes the same bytes a second time.
+func (f *faultConn) Write(b []byte) (int, error) {
+	f.mutex.Lock()
+	if f.spec.blackholeAfter > 0 && time.Since(f.start) >= f.spec.blackholeAfter {
+		f.mutex.Unlock()
+		log.Info("fault injector: blackhole window active, dropping write")
+		return len(b), nil
+	}
+	if f.dropAfterBytes > 0 && f.bytesWritten >= f.dropAfterBytes {
+		f.closedByUs = true
+		f.mutex.Unlock()
+		f.Conn.Close()
+		return 0, fmt.Errorf("fault injector: closed connection after %d bytes", f.dropAfterBytes)
+	}
+	f.bytesWritten += len(b)
+	f.mutex.Unlock()
+
+	if f.spec.lossFraction > 0 && rand.Float64() < f.spec.lossFraction {
+		log.Debug("fault injector: dropping write")
+		return len(b), nil
+	}
+
+	delay := f.spec.latency
+	if f.spec.jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(f.spec.jitter)))
+	}
+	if f.spec.reorderFrac > 0 && rand.Float64() < f.spec.reorderFrac {
+		// Simulate reordering by adding one more latency+jitter of delay so
+		// this write is more likely to land after ones that follow it.
+		delay += f.spec.latency + f.spec.jitter
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	n, err := f.Conn.Write(b)
+	if f.spec.dupFraction > 0 && rand.Float64() < f.spec.dupFraction {
+		log.Debug("fault injector: duplicating write")
+		f.Conn.Write(b)
+	}
+	return n, err
+}
+
+// wrapFault wraps conn in a faultConn if any fault injection was requested,
+// otherwise it hands conn back unchanged.
+func wrapFault(conn net.Conn, spec *faultSpec, dropAfterBytes int, closeAfter time.Duration) net.Conn {
+	if spec == nil {
+		spec = &faultSpec{}
+	}
+	if *spec == (faultSpec{}) && dropAfterBytes == 0 && closeAfter == 0 {
+		return conn
+	}
+	return newFaultConn(conn, spec, dropAfterBytes, closeAfter)
+}
+
+// argString reads a docopt string option, returning "" if it wasn't set.
+func argString(arguments map[string]interface{}, key string) string {
+	if v, ok := arguments[key]; ok && v != nil {
+		return v.(string)
+	}
+	return ""
+}
+
+func (f *faultConn) Close() error {
+	f.mutex.Lock()
+	already := f.closedByUs
+	f.mutex.Unlock()
+	if already {
+		// Already closed by the close-after timer.
+		return nil
+	}
+	return f.Conn.Close()
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2019 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// isL7Protocol reports whether protocol names one of the L7 probe modes this
+// file implements, as opposed to the plain L4 echo modes handled in
+// test-connection.go.
+func isL7Protocol(protocol string) bool {
+	switch protocol {
+	case "http", "https-sni", "dns", "grpc", "quic", "http3":
+		return true
+	}
+	return false
+}
+
+// runL7Probe drives a single L7 probe against remoteAddr and validates that
+// the response has the expected shape for the protocol.  name is the
+// Host header (http), SNI name (https-sni) or query name (dns); it's
+// ignored for grpc.  Unlike the L4 echo modes, this isn't a loop -- it's a
+// single connect/send/validate used to prove that L7-aware policy (SNI
+// rules, HTTP host filtering, DNS policy) actually inspected the payload.
+func runL7Probe(protocol, remoteAddr, name string) error {
+	switch protocol {
+	case "http":
+		return probeHTTP(remoteAddr, name)
+	case "https-sni":
+		return probeTLSClientHello(remoteAddr, name)
+	case "dns":
+		return probeDNS(remoteAddr, name)
+	case "grpc":
+		return probeGRPC(remoteAddr)
+	case "quic":
+		return probeQUIC(remoteAddr, name)
+	case "http3":
+		return probeHTTP3(remoteAddr, name)
+	}
+	return fmt.Errorf("unknown L7 protocol %q", protocol)
+}
+
+func probeHTTP(remoteAddr, host string) error {
+	if host == "" {
+		host = "example.com"
+	}
+	conn, err := net.Dial("tcp", remoteAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := fmt.Sprintf("GET / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", host)
+	log.WithField("host", host).Info("Sending HTTP request")
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return err
+	}
+
+	statusLine, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read HTTP response: %w", err)
+	}
+	statusLine = strings.TrimSpace(statusLine)
+	if !strings.HasPrefix(statusLine, "HTTP/1.") {
+		return fmt.Errorf("unexpected HTTP response shape: %q", statusLine)
+	}
+	log.WithField("status", statusLine).Info("Got HTTP response")
+	return nil
+}
+
+// probeTLSClientHello sends a minimal, well-formed TLS 1.2 ClientHello
+// carrying the given SNI server name.  It does not attempt to complete the
+// handshake -- the point is just to let SNI-aware policy see (and act on)
+// the server name before the connection is torn down.
+func probeTLSClientHello(remoteAddr, sni string) error {
+	if sni == "" {
+		sni = "example.com"
+	}
+	conn, err := net.Dial("tcp", remoteAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	hello := buildClientHello(sni)
+	log.WithField("sni", sni).Info("Sending TLS ClientHello")
+	if _, err := conn.Write(hello); err != nil {
+		return err
+	}
+	return nil
+}
+
+func buildClientHello(sni string) []byte {
+	var random [32]byte
+	rand.Read(random[:])
+
+	// Server Name extension: RFC 6066, type=0 (host_name).
+	sniName := []byte(sni)
+	serverNameEntry := append([]byte{0x00}, u16(len(sniName))...)
+	serverNameEntry = append(serverNameEntry, sniName...)
+	serverNameList := append(u16(len(serverNameEntry)), serverNameEntry...)
+	sniExt := append([]byte{0x00, 0x00}, u16(len(serverNameList))...) // extension type 0 = server_name
+	sniExt = append(sniExt, serverNameList...)
+
+	extensions := sniExt
+
+	var body []byte
+	body = append(body, 0x03, 0x03) // client_version: TLS 1.2
+	body = append(body, random[:]...)
+	body = append(body, 0x00)             // session_id length
+	body = append(body, u16(2)...)        // cipher_suites length
+	body = append(body, 0x00, 0x2f)       // TLS_RSA_WITH_AES_128_CBC_SHA
+	body = append(body, 0x01, 0x00)       // compression_methods: 1 entry, null
+	body = append(body, u16(len(extensions))...)
+	body = append(body, extensions...)
+
+	handshake := append([]byte{0x01}, u24(len(body))...) // handshake type 1 = client_hello
+	handshake = append(handshake, body...)
+
+	record := append([]byte{0x16, 0x03, 0x01}, u16(len(handshake))...) // content type 22 = handshake, TLS 1.0 record version
+	record = append(record, handshake...)
+	return record
+}
+
+func u16(n int) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, uint16(n))
+	return b
+}
+
+func u24(n int) []byte {
+	b := make([]byte, 3)
+	b[0] = byte(n >> 16)
+	b[1] = byte(n >> 8)
+	b[2] = byte(n)
+	return b
+}
+
+// probeDNS sends a single A-record query for name over UDP and checks that
+// the reply is a syntactically valid DNS message with a matching query ID.
+func probeDNS(remoteAddr, name string) error {
+	if name == "" {
+		name = "example.com"
+	}
+	conn, err := net.Dial("udp", remoteAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	id := uint16(rand.Intn(1 << 16))
+	query := buildDNSQuery(id, name)
+	log.WithField("name", name).Info("Sending DNS A query")
+	if _, err := conn.Write(query); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return fmt.Errorf("failed to read DNS response: %w", err)
+	}
+	if n < 12 {
+		return fmt.Errorf("DNS response too short (%d bytes)", n)
+	}
+	gotID := binary.BigEndian.Uint16(buf[0:2])
+	if gotID != id {
+		return fmt.Errorf("DNS response ID mismatch: sent %d, got %d", id, gotID)
+	}
+	flags := binary.BigEndian.Uint16(buf[2:4])
+	if flags&0x8000 == 0 {
+		return fmt.Errorf("DNS response QR bit not set, got flags 0x%04x", flags)
+	}
+	log.Info("Got well-formed DNS response")
+	return nil
+}
+
+func buildDNSQuery(id uint16, name string) []byte {
+	var msg []byte
+	msg = append(msg, byte(id>>8), byte(id))
+	msg = append(msg, 0x01, 0x00) // flags: standard query, recursion desired
+	msg = append(msg, 0x00, 0x01) // QDCOUNT=1
+	msg = append(msg, 0x00, 0x00) // ANCOUNT=0
+	msg = append(msg, 0x00, 0x00) // NSCOUNT=0
+	msg = append(msg, 0x00, 0x00) // ARCOUNT=0
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0x00)      // root label
+	msg = append(msg, 0x00, 0x01) // QTYPE=A
+	msg = append(msg, 0x00, 0x01) // QCLASS=IN
+	return msg
+}
+
+// probeGRPC sends a minimal unary gRPC request frame (a single HTTP/2-style
+// length-prefixed message after the usual h2c preface) and checks that the
+// server at least accepted the bytes.  It doesn't validate a full response
+// since most FV "servers" here are plain TCP echo listeners -- the purpose
+// is to exercise gRPC/HTTP2-detection in L7 policy, not to be a gRPC client.
+func probeGRPC(remoteAddr string) error {
+	conn, err := net.Dial("tcp", remoteAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// HTTP/2 connection preface, RFC 7540 section 3.5.
+	preface := []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+	log.Info("Sending HTTP/2 preface for gRPC probe")
+	if _, err := conn.Write(preface); err != nil {
+		return err
+	}
+
+	// A trivial length-prefixed gRPC message frame: 1 byte compressed-flag,
+	// 4 byte big-endian length, then an empty protobuf payload.
+	frame := []byte{0x00, 0x00, 0x00, 0x00, 0x00}
+	if _, err := conn.Write(frame); err != nil {
+		return err
+	}
+	return nil
+}
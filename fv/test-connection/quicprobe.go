@@ -0,0 +1,108 @@
+// Copyright (c) 2019 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
+	log "github.com/sirupsen/logrus"
+)
+
+const quicDialTimeout = 2 * time.Second
+
+// quicTLSConfig builds the (intentionally permissive) TLS config used for
+// QUIC/HTTP3 probes: FV test servers use self-signed certs, and the point of
+// these probes is to exercise SNI-aware policy, not certificate validation.
+func quicTLSConfig(sni string) *tls.Config {
+	return &tls.Config{
+		ServerName:         sni,
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h3", "quic-test"},
+	}
+}
+
+// probeQUIC opens a QUIC connection, carrying sni in the TLS ClientHello,
+// opens a single bidirectional stream and sends a test message -- enough for
+// SNI-based or UDP/QUIC-detecting policy to see the traffic shape.
+func probeQUIC(remoteAddr, sni string) error {
+	if sni == "" {
+		sni = "example.com"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), quicDialTimeout)
+	defer cancel()
+
+	log.WithFields(log.Fields{"addr": remoteAddr, "sni": sni}).Info("Dialing QUIC")
+	sess, err := quic.DialAddrContext(ctx, remoteAddr, quicTLSConfig(sni), nil)
+	if err != nil {
+		return fmt.Errorf("failed to establish QUIC session: %w", err)
+	}
+	defer sess.CloseWithError(0, "test-connection done")
+
+	stream, err := sess.OpenStreamSync(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open QUIC stream: %w", err)
+	}
+	defer stream.Close()
+
+	if _, err := stream.Write([]byte("hello-quic\n")); err != nil {
+		return fmt.Errorf("failed to write to QUIC stream: %w", err)
+	}
+	log.Info("Sent test message over QUIC")
+	return nil
+}
+
+// probeHTTP3 issues a single GET over HTTP/3 (QUIC transport) with the given
+// Host/SNI, and checks that a response with a status line came back.
+func probeHTTP3(remoteAddr, host string) error {
+	if host == "" {
+		host = "example.com"
+	}
+	client := &http.Client{
+		Transport: &http3.RoundTripper{
+			TLSClientConfig: quicTLSConfig(host),
+		},
+		Timeout: quicDialTimeout,
+	}
+	defer client.Transport.(*http3.RoundTripper).Close()
+
+	url := fmt.Sprintf("https://%s/", remoteAddr)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Host = host
+
+	log.WithFields(log.Fields{"url": url, "host": host}).Info("Sending HTTP/3 request")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP/3 request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		return fmt.Errorf("failed to read HTTP/3 response body: %w", err)
+	}
+	if resp.StatusCode == 0 {
+		return fmt.Errorf("unexpected HTTP/3 response shape: no status code")
+	}
+	log.WithField("status", resp.StatusCode).Info("Got HTTP/3 response")
+	return nil
+}
@@ -41,13 +41,17 @@ import (
 const usage = `test-connection: test connection to some target, for Felix FV testing.
 
 Usage:
-  test-connection <namespace-path> <ip-address> <port> [--source-ip=<source_ip>] [--source-port=<source>] [--protocol=<protocol>] [--loop-with-file=<file>]
+  test-connection <namespace-path> <ip-address> <port> [--source-ip=<source_ip>] [--source-port=<source>] [--protocol=<protocol>] [--loop-with-file=<file>] [--fault=<fault_spec>] [--drop-after-bytes=<n>] [--close-after=<duration>] [--l7-name=<name>]
 
 Options:
   --source-ip=<source_ip> Source IP to use for the connection [default: 0.0.0.0].
   --source-port=<source_port>  Source port to use for the connection [default: 0].
-  --protocol=<protocol>   Protocol to test [default: tcp].
+  --protocol=<protocol>   Protocol to test: tcp, udp, sctp, http, https-sni, dns, grpc, quic or http3 [default: tcp].
   --loop-with-file=<file>  Whether to send messages repeatedly, file is used for synchronization
+  --fault=<fault_spec>    Simulate adverse network conditions, e.g. "latency=50ms,jitter=10ms,loss=1%,dup=0.5%,reorder=5%,blackhole-after=3s".
+  --drop-after-bytes=<n>  Close the connection (returning an error from the next write) after this many bytes have been written.
+  --close-after=<duration> Close the connection unconditionally after this much time has elapsed.
+  --l7-name=<name>        For --protocol=http, the Host header; for https-sni, the SNI name; for dns, the query name to resolve.
 
 If connection is successful, test-connection exits successfully.
 
@@ -93,6 +97,25 @@ func main() {
 		loopFile = arg.(string)
 	}
 
+	fault, err := parseFaultSpec(argString(arguments, "--fault"))
+	if err != nil {
+		log.WithError(err).Fatal("Invalid --fault spec")
+	}
+	dropAfterBytes := 0
+	if s := argString(arguments, "--drop-after-bytes"); s != "" {
+		dropAfterBytes, err = strconv.Atoi(s)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid --drop-after-bytes")
+		}
+	}
+	var closeAfter time.Duration
+	if s := argString(arguments, "--close-after"); s != "" {
+		closeAfter, err = time.ParseDuration(s)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid --close-after")
+		}
+	}
+
 	if loopFile == "" {
 		// I found that configuring the timeouts on all the
 		// network calls was a bit fiddly.  Since it leaves
@@ -104,12 +127,33 @@ func main() {
 		}()
 	}
 
+	l7Name := argString(arguments, "--l7-name")
+
+	if isL7Protocol(protocol) {
+		if namespacePath == "-" {
+			err = runL7Probe(protocol, net.JoinHostPort(ipAddress, port), l7Name)
+		} else {
+			var namespace ns.NetNS
+			namespace, err = ns.GetNS(namespacePath)
+			if err != nil {
+				panic(err)
+			}
+			err = namespace.Do(func(_ ns.NetNS) error {
+				return runL7Probe(protocol, net.JoinHostPort(ipAddress, port), l7Name)
+			})
+		}
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
 	if namespacePath == "-" {
 		// Add an interface for the source IP if any.
 		err = maybeAddInterface(sourceIpAddress)
 		// Test connection from wherever we are already running.
 		if err == nil {
-			err = tryConnect(ipAddress, port, sourceIpAddress, sourcePort, protocol, loopFile)
+			err = tryConnect(ipAddress, port, sourceIpAddress, sourcePort, protocol, loopFile, fault, dropAfterBytes, closeAfter)
 		}
 	} else {
 		// Get the specified network namespace (representing a workload).
@@ -127,7 +171,7 @@ func main() {
 			if e != nil {
 				return e
 			}
-			return tryConnect(ipAddress, port, sourceIpAddress, sourcePort, protocol, loopFile)
+			return tryConnect(ipAddress, port, sourceIpAddress, sourcePort, protocol, loopFile, fault, dropAfterBytes, closeAfter)
 		})
 	}
 
@@ -145,7 +189,7 @@ func maybeAddInterface(sourceIP string) error {
 	return err
 }
 
-func tryConnect(remoteIpAddr, remotePort, sourceIpAddr, sourcePort, protocol, loopFile string) error {
+func tryConnect(remoteIpAddr, remotePort, sourceIpAddr, sourcePort, protocol, loopFile string, fault *faultSpec, dropAfterBytes int, closeAfter time.Duration) error {
 
 	err := utils.RunCommand("ip", "r")
 	if err != nil {
@@ -187,6 +231,7 @@ func tryConnect(remoteIpAddr, remotePort, sourceIpAddr, sourcePort, protocol, lo
 		if err != nil {
 			panic(err)
 		}
+		conn = wrapFault(conn, fault, dropAfterBytes, closeAfter)
 		defer conn.Close()
 
 		for {
@@ -227,7 +272,7 @@ func tryConnect(remoteIpAddr, remotePort, sourceIpAddr, sourcePort, protocol, lo
 		// the reuse.Dialer does not support SCTP, so set the needed reuse socket options
 		// and dial directly using the sctp library. (We use a forked copy of the library
 		// that allows setting the socket options in this way.)
-		conn, err := sctp.DialSCTPExt(
+		sctpConn, err := sctp.DialSCTPExt(
 			"sctp",
 			laddr,
 			raddr,
@@ -238,6 +283,7 @@ func tryConnect(remoteIpAddr, remotePort, sourceIpAddr, sourcePort, protocol, lo
 		if err != nil {
 			panic(err)
 		}
+		var conn net.Conn = wrapFault(sctpConn, fault, dropAfterBytes, closeAfter)
 		defer conn.Close()
 		log.Infof("SCTP connection established")
 
@@ -270,6 +316,7 @@ func tryConnect(remoteIpAddr, remotePort, sourceIpAddr, sourcePort, protocol, lo
 		if err != nil {
 			return err
 		}
+		conn = wrapFault(conn, fault, dropAfterBytes, closeAfter)
 		defer conn.Close()
 		log.Infof("TCP connection established")
 
@@ -0,0 +1,67 @@
+// Copyright (c) 2019 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	pb "github.com/projectcalico/felix/fv/test-workload/proto"
+)
+
+// echoServer implements pb.EchoServiceServer with a trivial unary Echo and a
+// bidi-stream Echo that just sends back whatever it's sent, so FV tests can
+// exercise both gRPC call shapes over Calico's dataplane.
+type echoServer struct{}
+
+func (echoServer) Echo(_ context.Context, req *pb.EchoRequest) (*pb.EchoResponse, error) {
+	return &pb.EchoResponse{Message: req.Message}, nil
+}
+
+func (echoServer) EchoStream(stream pb.EchoService_EchoStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&pb.EchoResponse{Message: req.Message}); err != nil {
+			return err
+		}
+	}
+}
+
+// startGRPCEchoListener starts a gRPC server on addr exposing EchoService,
+// with both a unary Echo RPC and a bidi-streaming EchoStream RPC.
+func startGRPCEchoListener(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	pb.RegisterEchoServiceServer(server, echoServer{})
+	go func() {
+		if err := server.Serve(ln); err != nil {
+			log.WithError(err).WithField("addr", addr).Error("gRPC echo listener stopped")
+		}
+	}()
+	return nil
+}
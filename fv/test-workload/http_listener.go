@@ -0,0 +1,85 @@
+// Copyright (c) 2019 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// echoResponse is what the HTTP/HTTP2 echo handler writes back for every
+// request, so that FV tests can assert on L7 request attributes rather than
+// just "the connection worked".
+type echoResponse struct {
+	Method  string              `json:"method"`
+	Path    string              `json:"path"`
+	Proto   string              `json:"proto"`
+	Headers map[string][]string `json:"headers"`
+	Body    string              `json:"body"`
+}
+
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := echoResponse{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Proto:   r.Proto,
+		Headers: map[string][]string(r.Header),
+		Body:    string(body),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.WithError(err).Error("Failed to write echo response")
+	}
+}
+
+// startHTTPEchoListener starts an HTTP server on addr that echoes the
+// method, path, headers and body of every request back as JSON.  When
+// useH2C is true, the server additionally accepts cleartext HTTP/2
+// connections (h2c) so FV tests can exercise HTTP/2 framing without TLS.
+func startHTTPEchoListener(addr string, useH2C bool) error {
+	handler := http.HandlerFunc(echoHandler)
+
+	var server *http.Server
+	if useH2C {
+		server = &http.Server{
+			Addr:    addr,
+			Handler: h2c.NewHandler(handler, &http2.Server{}),
+		}
+	} else {
+		server = &http.Server{Addr: addr, Handler: handler}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := server.Serve(ln); err != nil {
+			log.WithError(err).WithField("addr", addr).Error("HTTP echo listener stopped")
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,97 @@
+// Hand-maintained stub standing in for protoc-gen-go/protoc-gen-go-grpc
+// output, since this tree has no protoc toolchain available. It mirrors
+// echo.proto by hand; keep the two in sync and update this file directly
+// if the service definition changes.
+
+package proto
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+type EchoRequest struct {
+	Message string `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+}
+
+func (m *EchoRequest) Reset()         { *m = EchoRequest{} }
+func (m *EchoRequest) String() string { return m.Message }
+func (*EchoRequest) ProtoMessage()    {}
+
+type EchoResponse struct {
+	Message string `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+}
+
+func (m *EchoResponse) Reset()         { *m = EchoResponse{} }
+func (m *EchoResponse) String() string { return m.Message }
+func (*EchoResponse) ProtoMessage()    {}
+
+// EchoServiceServer is the server API for EchoService.
+type EchoServiceServer interface {
+	Echo(context.Context, *EchoRequest) (*EchoResponse, error)
+	EchoStream(EchoService_EchoStreamServer) error
+}
+
+// EchoService_EchoStreamServer is the server-side bidi stream for EchoStream.
+type EchoService_EchoStreamServer interface {
+	Send(*EchoResponse) error
+	Recv() (*EchoRequest, error)
+	grpc.ServerStream
+}
+
+type echoServiceEchoStreamServer struct {
+	grpc.ServerStream
+}
+
+func (s *echoServiceEchoStreamServer) Send(resp *EchoResponse) error {
+	return s.ServerStream.SendMsg(resp)
+}
+
+func (s *echoServiceEchoStreamServer) Recv() (*EchoRequest, error) {
+	req := new(EchoRequest)
+	if err := s.ServerStream.RecvMsg(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func registerEchoServiceEcho(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	req := new(EchoRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	return srv.(EchoServiceServer).Echo(ctx, req)
+}
+
+func registerEchoServiceEchoStream(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EchoServiceServer).EchoStream(&echoServiceEchoStreamServer{stream})
+}
+
+var echoServiceServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.EchoService",
+	HandlerType: (*EchoServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Echo",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				return registerEchoServiceEcho(srv, ctx, dec)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "EchoStream",
+			Handler:       registerEchoServiceEchoStream,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "echo.proto",
+}
+
+// RegisterEchoServiceServer registers srv as the implementation backing the
+// EchoService on server s.
+func RegisterEchoServiceServer(s *grpc.Server, srv EchoServiceServer) {
+	s.RegisterService(&echoServiceServiceDesc, srv)
+}
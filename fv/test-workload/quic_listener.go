@@ -0,0 +1,95 @@
+// Copyright (c) 2019 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"math/big"
+
+	quic "github.com/lucas-clemente/quic-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// startQUICEchoListener starts a QUIC endpoint on addr, backed by a
+// throwaway self-signed certificate, and echoes every stream it's sent back
+// to the peer. This lets FV tests exercise BPF conntrack/NAT handling of
+// QUIC's UDP-based transport.
+func startQUICEchoListener(addr string) error {
+	tlsConf, err := selfSignedTLSConfig()
+	if err != nil {
+		return err
+	}
+	ln, err := quic.ListenAddr(addr, tlsConf, nil)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			sess, err := ln.Accept(context.Background())
+			if err != nil {
+				log.WithError(err).WithField("addr", addr).Error("QUIC echo listener stopped accepting sessions")
+				return
+			}
+			go handleQUICSession(sess)
+		}
+	}()
+	return nil
+}
+
+func handleQUICSession(sess quic.Session) {
+	for {
+		stream, err := sess.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			defer stream.Close()
+			if _, err := io.Copy(stream, stream); err != nil {
+				log.WithError(err).Debug("QUIC echo stream ended")
+			}
+		}()
+	}
+}
+
+// selfSignedTLSConfig generates a fresh, throwaway self-signed certificate
+// for the QUIC listener; FV tests only care about the transport, not cert
+// validation, so the client side is expected to skip verification.
+func selfSignedTLSConfig() (*tls.Config, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	template := x509.Certificate{SerialNumber: big.NewInt(1)}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"test-workload-echo"},
+	}, nil
+}
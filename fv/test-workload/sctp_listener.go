@@ -0,0 +1,61 @@
+// Copyright (c) 2019 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ishidawataru/sctp"
+	log "github.com/sirupsen/logrus"
+)
+
+// startSCTPEchoListener starts a one-to-one SCTP listener on addr and echoes
+// back whatever it reads on each accepted association, so FV tests can
+// exercise BPF conntrack/NAT handling of the SCTP protocol.
+func startSCTPEchoListener(addr string) error {
+	sctpAddr, err := sctp.ResolveSCTPAddr("sctp", addr)
+	if err != nil {
+		return err
+	}
+	ln, err := sctp.ListenSCTP("sctp", sctpAddr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer ln.Close()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.WithError(err).WithField("addr", addr).Error("SCTP echo listener stopped accepting")
+				return
+			}
+			go handleSCTPConn(conn)
+		}
+	}()
+	return nil
+}
+
+func handleSCTPConn(conn *sctp.SCTPConn) {
+	defer conn.Close()
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write(buf[:n]); err != nil {
+			log.WithError(err).Error("Failed to write SCTP echo response")
+			return
+		}
+	}
+}
@@ -17,10 +17,13 @@ package main
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/containernetworking/cni/pkg/ns"
@@ -28,6 +31,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
 
+	"github.com/projectcalico/felix/bpf/conntrack"
 	"github.com/projectcalico/felix/fv/utils"
 )
 
@@ -36,7 +40,29 @@ const usage = `test-workload, test workload for Felix FV testing.
 If <interface-name> is "", the workload will start in the current namespace.
 
 Usage:
-  test-workload [--udp] [--namespace-path=<path>] [--sidecar-iptables] [--up-lo] <interface-name> <ip-address> <ports>
+  test-workload [--udp] [--udp-connected] [--namespace-path=<path>] [--sidecar-iptables] [--sidecar-mode=<mode>] [--sidecar-uid=<uid>] [--sidecar-gid=<gid>] [--sidecar-include-outbound-cidrs=<cidrs>] [--sidecar-exclude-outbound-cidrs=<cidrs>] [--sidecar-exclude-inbound-ports=<ports>] [--up-lo] [--dns=<ips>] [--search=<domain>] [--dns-record=<mapping>]... <interface-name> <ip-address> <ports>
+
+<ports> is a comma-separated list of "port" or "port/proto" entries, e.g. "8080,9090/http,9091/grpc".
+proto defaults to udp or tcp (depending on --udp) and may otherwise be one of: tcp, udp, http, http2, grpc, quic, sctp.
+
+Options:
+  --udp-connected                          For plain "udp" ports, answer each packet from a new socket dialled
+                                            back to its source with net.DialUDP, so the kernel installs a
+                                            connected UDP socket, rather than replying from the shared listening socket.
+  --dns=<ips>                              Comma-separated nameserver IPs to write into this workload's /etc/resolv.conf.
+  --search=<domain>                        Search domain to write into this workload's /etc/resolv.conf.
+  --dns-record=<mapping>                   A "name=ip" pair the built-in DNS responder should answer for; may be repeated.
+                                            When any --dns-record is given, the workload also listens on UDP/53.
+  --sidecar-mode=<mode>                    "redirect" (REDIRECT to 15001/15006) or "tproxy" (TPROXY with a
+                                            mark-based ip rule/ip route) [default: redirect].
+  --sidecar-uid=<uid>                      UID of the simulated envoy process; its own outbound traffic bypasses
+                                            the redirect so it doesn't loop back on itself [default: 1337].
+  --sidecar-gid=<gid>                      GID of the simulated envoy process, exempted the same way as the UID [default: 1337].
+  --sidecar-include-outbound-cidrs=<cidrs> Comma-separated CIDRs to redirect outbound; all other destinations
+                                            are left alone [default: 0.0.0.0/0].
+  --sidecar-exclude-outbound-cidrs=<cidrs> Comma-separated CIDRs to exempt from outbound redirection, checked
+                                            before the include list.
+  --sidecar-exclude-inbound-ports=<ports>  Comma-separated TCP ports to exempt from inbound redirection.
 `
 
 func main() {
@@ -51,15 +77,38 @@ func main() {
 	ipAddress := arguments["<ip-address>"].(string)
 	portsStr := arguments["<ports>"].(string)
 	udp := arguments["--udp"].(bool)
+	udpConnected := arguments["--udp-connected"].(bool)
 	nsPath := ""
 	if arg, ok := arguments["--namespace-path"]; ok && arg != nil {
 		nsPath = arg.(string)
 	}
 	sidecarIptables := arguments["--sidecar-iptables"].(bool)
+	sidecarCfg := sidecarConfig{
+		mode:                 arguments["--sidecar-mode"].(string),
+		uid:                  arguments["--sidecar-uid"].(string),
+		gid:                  arguments["--sidecar-gid"].(string),
+		includeOutboundCIDRs: strings.Split(arguments["--sidecar-include-outbound-cidrs"].(string), ","),
+	}
+	if arg, ok := arguments["--sidecar-exclude-outbound-cidrs"]; ok && arg != nil {
+		sidecarCfg.excludeOutboundCIDRs = strings.Split(arg.(string), ",")
+	}
+	if arg, ok := arguments["--sidecar-exclude-inbound-ports"]; ok && arg != nil {
+		sidecarCfg.excludeInboundPorts = strings.Split(arg.(string), ",")
+	}
 	upLo := arguments["--up-lo"].(bool)
+	var dnsServers []string
+	if arg, ok := arguments["--dns"]; ok && arg != nil {
+		dnsServers = strings.Split(arg.(string), ",")
+	}
+	searchDomain := ""
+	if arg, ok := arguments["--search"]; ok && arg != nil {
+		searchDomain = arg.(string)
+	}
+	dnsRecords, err := parseDNSRecords(arguments["--dns-record"].([]string))
 	panicIfError(err)
 
-	ports := strings.Split(portsStr, ",")
+	ports, err := parsePortSpecs(portsStr, udp)
+	panicIfError(err)
 
 	var namespace ns.NetNS
 	if nsPath != "" {
@@ -238,6 +287,8 @@ func main() {
 	// effectively means _as_ this workload.
 	fmt.Println(namespace.Path())
 
+	setUpSIGTERMCleanup(interfaceName, nsPath, ipAddress)
+
 	// Now listen on the specified ports in the workload namespace.
 	err = namespace.Do(func(_ ns.NetNS) error {
 		if upLo {
@@ -246,10 +297,20 @@ func main() {
 			}
 		}
 		if sidecarIptables {
-			if err := doSidecarIptablesSetup(); err != nil {
+			if err := doSidecarIptablesSetup(sidecarCfg); err != nil {
 				return fmt.Errorf("failed to setup sidecar-like iptables: %v", err)
 			}
 		}
+		if len(dnsServers) > 0 || searchDomain != "" {
+			if err := writeResolvConf(dnsServers, searchDomain); err != nil {
+				return fmt.Errorf("failed to provision resolv.conf: %v", err)
+			}
+		}
+		if len(dnsRecords) > 0 {
+			if err := startDNSResponder(dnsRecords); err != nil {
+				return fmt.Errorf("failed to start DNS responder: %v", err)
+			}
+		}
 		if strings.Contains(ipAddress, ":") {
 			attempts := 0
 			for {
@@ -292,19 +353,20 @@ func main() {
 			}
 		}
 
-		// Listen on each port for either TCP or UDP.
-		for _, port := range ports {
+		// Listen on each port, using whichever protocol it was tagged with.
+		for _, spec := range ports {
 			var myAddr string
 			if strings.Contains(ipAddress, ":") {
-				myAddr = "[" + ipAddress + "]:" + port
+				myAddr = "[" + ipAddress + "]:" + spec.port
 			} else {
-				myAddr = ipAddress + ":" + port
+				myAddr = ipAddress + ":" + spec.port
 			}
 			logCxt := log.WithFields(log.Fields{
-				"udp":    udp,
+				"proto":  spec.proto,
 				"myAddr": myAddr,
 			})
-			if udp {
+			switch spec.proto {
+			case "udp":
 				// Since UDP is connectionless, we can't use Listen() as we do for TCP.  Instead,
 				// we use ListenPacket so that we can directly send/receive individual packets.
 				logCxt.Info("About to listen for UDP packets")
@@ -318,11 +380,28 @@ func main() {
 						buffer := make([]byte, 1024)
 						n, addr, err := p.ReadFrom(buffer)
 						panicIfError(err)
+						if udpConnected {
+							// Dial back to the source so the kernel installs a connected
+							// UDP socket for this flow, exercising the connected-UDP path
+							// in the eBPF conntrack code rather than the shared socket path.
+							if err := respondOverConnectedUDP(myAddr, addr, buffer[:n]); err != nil {
+								logCxt.WithError(err).WithField("remoteAddr", addr).Error("Failed to respond over connected UDP socket")
+							}
+							continue
+						}
 						_, err = p.WriteTo(buffer[:n], addr)
 						logCxt.WithError(err).WithField("remoteAddr", addr).Info("Responded")
 					}
 				}()
-			} else {
+			case "quic":
+				logCxt.Info("About to start QUIC echo listener")
+				err := startQUICEchoListener(myAddr)
+				panicIfError(err)
+			case "sctp":
+				logCxt.Info("About to start SCTP echo listener")
+				err := startSCTPEchoListener(myAddr)
+				panicIfError(err)
+			case "tcp":
 				logCxt.Info("About to listen for TCP connections")
 				l, err := net.Listen("tcp", myAddr)
 				panicIfError(err)
@@ -335,6 +414,16 @@ func main() {
 						go handleRequest(conn)
 					}
 				}()
+			case "http", "http2":
+				logCxt.Info("About to start HTTP echo listener")
+				err := startHTTPEchoListener(myAddr, spec.proto == "http2")
+				panicIfError(err)
+			case "grpc":
+				logCxt.Info("About to start gRPC echo listener")
+				err := startGRPCEchoListener(myAddr)
+				panicIfError(err)
+			default:
+				log.WithField("proto", spec.proto).Panic("Unknown --proto")
 			}
 		}
 		for {
@@ -350,6 +439,43 @@ func panicIfError(err error) {
 	}
 }
 
+// setUpSIGTERMCleanup arranges for a clean(-ish) teardown on SIGTERM: delete
+// the host end of the veth we created (if any), which also drops the
+// workload's netns once the last reference to it goes away, and flush any
+// conntrack entries for this workload's IP so a later test reusing the same
+// IP doesn't see stale flow state.
+func setUpSIGTERMCleanup(interfaceName, nsPath, ipAddress string) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		log.Info("Received SIGTERM, cleaning up before exit")
+
+		if interfaceName != "" && nsPath == "" {
+			// We only own the veth (and its netns) if we created it ourselves,
+			// rather than being pointed at a pre-existing namespace.
+			if link, err := netlink.LinkByName(interfaceName); err != nil {
+				log.WithError(err).Warn("Failed to look up veth for cleanup")
+			} else if err := netlink.LinkDel(link); err != nil {
+				log.WithError(err).Warn("Failed to delete veth on SIGTERM")
+			}
+		}
+
+		if ip := net.ParseIP(ipAddress); ip != nil {
+			m, err := conntrack.OpenPinnedMap(conntrack.DefaultPinPath)
+			if err != nil {
+				log.WithError(err).Warn("Failed to open pinned conntrack map for cleanup")
+			} else if deleted, err := conntrack.ScanAndDeleteByIP(m, ip); err != nil {
+				log.WithError(err).Warn("Failed to scan/delete conntrack entries for workload IP")
+			} else {
+				log.WithField("deleted", deleted).Info("Flushed conntrack entries for workload IP")
+			}
+		}
+
+		os.Exit(0)
+	}()
+}
+
 // writeProcSys takes the sysctl path and a string value to set i.e. "0" or "1" and sets the sysctl.
 func writeProcSys(path, value string) error {
 	f, err := os.OpenFile(path, os.O_WRONLY, 0)
@@ -366,19 +492,337 @@ func writeProcSys(path, value string) error {
 	return err
 }
 
-// doSidecarIptablesSetup generates some iptables rules to redirect a
-// traffic to localhost:15001. This is to simulate a sidecar.
-//
-// Commands are a very simplified version of commands from
+// portSpec is a single "<port>[/<proto>]" entry from the <ports> argument.
+type portSpec struct {
+	port  string
+	proto string
+}
+
+var validPortProtos = map[string]bool{
+	"tcp": true, "udp": true, "http": true, "http2": true, "grpc": true, "quic": true, "sctp": true,
+}
+
+// respondOverConnectedUDP dials a new UDP socket back to src from the same
+// local address as listenAddr and writes data on it, rather than replying
+// from the shared listening socket. This causes the kernel to install a
+// connected 4-tuple UDP socket for the flow.
+func respondOverConnectedUDP(listenAddr string, src net.Addr, data []byte) error {
+	localAddr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		return err
+	}
+	remoteAddr, err := net.ResolveUDPAddr("udp", src.String())
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialUDP("udp", localAddr, remoteAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(data)
+	return err
+}
+
+// parsePortSpecs parses the comma-separated <ports> argument into a list of
+// portSpecs, defaulting the protocol to "udp" or "tcp" (per defaultUDP) for
+// entries that don't specify one explicitly.
+func parsePortSpecs(portsStr string, defaultUDP bool) ([]portSpec, error) {
+	defaultProto := "tcp"
+	if defaultUDP {
+		defaultProto = "udp"
+	}
+
+	var specs []portSpec
+	for _, entry := range strings.Split(portsStr, ",") {
+		port := entry
+		proto := defaultProto
+		if idx := strings.Index(entry, "/"); idx != -1 {
+			port = entry[:idx]
+			proto = entry[idx+1:]
+		}
+		if !validPortProtos[proto] {
+			return nil, fmt.Errorf("invalid proto %q for port %q", proto, port)
+		}
+		specs = append(specs, portSpec{port: port, proto: proto})
+	}
+	return specs, nil
+}
+
+// parseDNSRecords turns a list of "name=ip" strings (as passed via repeated
+// --dns-record flags) into a name -> IP map for the built-in DNS responder.
+func parseDNSRecords(mappings []string) (map[string]net.IP, error) {
+	records := map[string]net.IP{}
+	for _, m := range mappings {
+		parts := strings.SplitN(m, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid --dns-record %q, expected name=ip", m)
+		}
+		name := parts[0]
+		ip := net.ParseIP(parts[1])
+		if ip == nil {
+			return nil, fmt.Errorf("invalid --dns-record %q, %q is not an IP", m, parts[1])
+		}
+		if !strings.HasSuffix(name, ".") {
+			name += "."
+		}
+		records[name] = ip
+	}
+	return records, nil
+}
+
+// writeResolvConf bind-mounts a tmpfs over /etc/resolv.conf in the current
+// (network) namespace and populates it with the given nameservers and search
+// domain, mirroring the per-namespace resolv.conf handling that container
+// runtimes do for pods.
+func writeResolvConf(dnsServers []string, searchDomain string) error {
+	var buf strings.Builder
+	for _, server := range dnsServers {
+		fmt.Fprintf(&buf, "nameserver %s\n", server)
+	}
+	if searchDomain != "" {
+		fmt.Fprintf(&buf, "search %s\n", searchDomain)
+	}
+
+	if err := utils.RunCommand("mount", "-t", "tmpfs", "tmpfs-resolv-conf", "/etc"); err != nil {
+		return fmt.Errorf("failed to mount tmpfs over /etc: %v", err)
+	}
+	if err := ioutil.WriteFile("/etc/resolv.conf", []byte(buf.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write /etc/resolv.conf: %v", err)
+	}
+	log.WithField("resolvConf", buf.String()).Info("Wrote /etc/resolv.conf")
+	return nil
+}
+
+// startDNSResponder starts a minimal UDP DNS server on port 53 that answers
+// A/AAAA queries from a static name -> IP map, so that FV tests can exercise
+// egress DNS policy without pulling in a real resolver like dnsmasq.
+func startDNSResponder(records map[string]net.IP) error {
+	conn, err := net.ListenPacket("udp", ":53")
+	if err != nil {
+		return err
+	}
+	log.WithField("records", records).Info("Listening for DNS queries")
+	go func() {
+		defer conn.Close()
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				log.WithError(err).Error("Failed to read DNS query")
+				return
+			}
+			resp, err := buildDNSResponse(buf[:n], records)
+			if err != nil {
+				log.WithError(err).Warn("Failed to build DNS response, ignoring query")
+				continue
+			}
+			if _, err := conn.WriteTo(resp, addr); err != nil {
+				log.WithError(err).Error("Failed to write DNS response")
+			}
+		}
+	}()
+	return nil
+}
+
+// buildDNSResponse parses a single-question DNS query and, if the queried
+// name is in records, returns a reply with a matching A or AAAA answer.
+// It only understands the minimal subset of the wire format needed for
+// simple stub-resolver queries; anything else is rejected with an error.
+func buildDNSResponse(query []byte, records map[string]net.IP) ([]byte, error) {
+	if len(query) < 12 {
+		return nil, fmt.Errorf("query too short")
+	}
+	qdCount := int(query[4])<<8 | int(query[5])
+	if qdCount != 1 {
+		return nil, fmt.Errorf("expected exactly one question, got %d", qdCount)
+	}
+
+	name, offset, err := readDNSName(query, 12)
+	if err != nil {
+		return nil, err
+	}
+	if offset+4 > len(query) {
+		return nil, fmt.Errorf("query truncated after question name")
+	}
+	qType := query[offset]<<8 | query[offset+1]
+	qClass := query[offset+2]<<8 | query[offset+3]
+
+	ip, ok := records[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("no record for %q", name)
+	}
+	var rdata []byte
+	switch {
+	case qType == 1 && ip.To4() != nil: // A
+		rdata = ip.To4()
+	case qType == 28 && ip.To4() == nil: // AAAA
+		rdata = ip.To16()
+	default:
+		return nil, fmt.Errorf("no matching record type %d for %q", qType, name)
+	}
+
+	resp := make([]byte, len(query))
+	copy(resp, query)
+	resp[2] = 0x85          // QR=1, opcode=0, AA=1, RD=1
+	resp[3] = 0x80          // RA=1
+	resp[6], resp[7] = 0, 1 // ANCOUNT=1
+
+	resp = append(resp,
+		0xc0, 0x0c, // pointer back to the question name
+		byte(qType>>8), byte(qType),
+		byte(qClass>>8), byte(qClass),
+		0, 0, 0, 30, // TTL 30s
+		byte(len(rdata)>>8), byte(len(rdata)),
+	)
+	resp = append(resp, rdata...)
+	return resp, nil
+}
+
+// readDNSName decodes an uncompressed DNS name starting at offset and
+// returns it dot-separated along with the offset of the byte following the
+// name; a compressed name (pointer label) is rejected with an error.
+func readDNSName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	for {
+		if offset >= len(msg) {
+			return "", 0, fmt.Errorf("name runs past end of message")
+		}
+		length := int(msg[offset])
+		if length == 0 {
+			offset++
+			break
+		}
+		if length&0xc0 != 0 {
+			return "", 0, fmt.Errorf("compressed names are not supported in queries")
+		}
+		offset++
+		if offset+length > len(msg) {
+			return "", 0, fmt.Errorf("label runs past end of message")
+		}
+		labels = append(labels, string(msg[offset:offset+length]))
+		offset += length
+	}
+	return strings.Join(labels, ".") + ".", offset, nil
+}
+
+// sidecarConfig describes how to simulate a mesh sidecar's iptables setup.
+// It loosely mirrors the flags understood by istio-iptables.sh.
+type sidecarConfig struct {
+	// mode is "redirect" (REDIRECT-based) or "tproxy" (TPROXY/mark-based).
+	mode string
+	// uid/gid identify the simulated envoy process; its own traffic bypasses
+	// the redirect rules so it doesn't get captured a second time.
+	uid, gid string
+	// includeOutboundCIDRs/excludeOutboundCIDRs gate which outbound traffic
+	// gets captured; exclude is checked first.
+	includeOutboundCIDRs, excludeOutboundCIDRs []string
+	// excludeInboundPorts lists TCP ports that bypass inbound capture.
+	excludeInboundPorts []string
+}
+
+const (
+	sidecarOutboundPort = "15001"
+	sidecarInboundPort  = "15006"
+	sidecarTproxyMark   = "0x400/0xfff"
+	sidecarTproxyTable  = "133"
+)
+
+// doSidecarIptablesSetup generates iptables rules that redirect outbound TCP
+// to sidecarOutboundPort and inbound TCP to sidecarInboundPort, to simulate a
+// mesh sidecar such as Istio's envoy. Commands are a simplified version of
 // https://github.com/istio/cni/blob/f1a08bef3f235de1ecb67074b741b0d4c5fd8c44/tools/deb/istio-iptables.sh
-func doSidecarIptablesSetup() error {
+func doSidecarIptablesSetup(cfg sidecarConfig) error {
+	switch cfg.mode {
+	case "", "redirect":
+		return doSidecarRedirectSetup(cfg)
+	case "tproxy":
+		return doSidecarTproxySetup(cfg)
+	default:
+		return fmt.Errorf("unknown --sidecar-mode %q", cfg.mode)
+	}
+}
+
+// doSidecarRedirectSetup wires up REDIRECT-based capture: outbound TCP is
+// redirected to localhost:<sidecarOutboundPort>, inbound TCP is redirected to
+// localhost:<sidecarInboundPort>, and the envoy UID/GID's own traffic is
+// exempted so it isn't captured a second time.
+func doSidecarRedirectSetup(cfg sidecarConfig) error {
 	cmds := [][]string{
 		{"iptables", "-t", "nat", "-N", "FV_WL_REDIRECT"},
-		{"iptables", "-t", "nat", "-A", "FV_WL_REDIRECT", "-p", "tcp", "-j", "REDIRECT", "--to-port", "15001"},
+		{"iptables", "-t", "nat", "-A", "FV_WL_REDIRECT", "-p", "tcp", "-j", "REDIRECT", "--to-port", sidecarOutboundPort},
+		{"iptables", "-t", "nat", "-N", "FV_WL_IN_REDIRECT"},
+		{"iptables", "-t", "nat", "-A", "FV_WL_IN_REDIRECT", "-p", "tcp", "-j", "REDIRECT", "--to-port", sidecarInboundPort},
+
 		{"iptables", "-t", "nat", "-N", "FV_WL_OUTPUT"},
 		{"iptables", "-t", "nat", "-A", "OUTPUT", "-p", "tcp", "-j", "FV_WL_OUTPUT"},
-		{"iptables", "-t", "nat", "-A", "FV_WL_OUTPUT", "!", "-d", "127.0.0.1/32", "-j", "FV_WL_REDIRECT"},
+		{"iptables", "-t", "nat", "-A", "FV_WL_OUTPUT", "-o", "lo", "-j", "RETURN"},
+		{"iptables", "-t", "nat", "-A", "FV_WL_OUTPUT", "-m", "owner", "--uid-owner", cfg.uid, "-j", "RETURN"},
+		{"iptables", "-t", "nat", "-A", "FV_WL_OUTPUT", "-m", "owner", "--gid-owner", cfg.gid, "-j", "RETURN"},
+	}
+	for _, cidr := range cfg.excludeOutboundCIDRs {
+		cmds = append(cmds, []string{"iptables", "-t", "nat", "-A", "FV_WL_OUTPUT", "-d", cidr, "-j", "RETURN"})
 	}
+	for _, cidr := range cfg.includeOutboundCIDRs {
+		cmds = append(cmds, []string{"iptables", "-t", "nat", "-A", "FV_WL_OUTPUT", "-d", cidr, "-j", "FV_WL_REDIRECT"})
+	}
+
+	cmds = append(cmds,
+		[]string{"iptables", "-t", "nat", "-N", "FV_WL_INBOUND"},
+		[]string{"iptables", "-t", "nat", "-A", "PREROUTING", "-p", "tcp", "-j", "FV_WL_INBOUND"},
+	)
+	for _, port := range cfg.excludeInboundPorts {
+		cmds = append(cmds, []string{"iptables", "-t", "nat", "-A", "FV_WL_INBOUND", "-p", "tcp", "--dport", port, "-j", "RETURN"})
+	}
+	cmds = append(cmds, []string{"iptables", "-t", "nat", "-A", "FV_WL_INBOUND", "-p", "tcp", "-j", "FV_WL_IN_REDIRECT"})
+
+	for _, cmd := range cmds {
+		if err := utils.RunCommand(cmd[0], cmd[1:]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// doSidecarTproxySetup wires up TPROXY-based capture in the mangle table: a
+// mark-based ip rule/ip route combination loops marked outbound packets back
+// through the local stack, and inbound packets are diverted to
+// sidecarInboundPort without changing their destination address.
+func doSidecarTproxySetup(cfg sidecarConfig) error {
+	cmds := [][]string{
+		{"iptables", "-t", "mangle", "-N", "FV_WL_DIVERT"},
+		{"iptables", "-t", "mangle", "-A", "FV_WL_DIVERT", "-j", "MARK", "--set-mark", sidecarTproxyMark},
+		{"iptables", "-t", "mangle", "-A", "FV_WL_DIVERT", "-j", "ACCEPT"},
+
+		{"iptables", "-t", "mangle", "-N", "FV_WL_TPROXY"},
+		{"iptables", "-t", "mangle", "-A", "FV_WL_TPROXY", "-p", "tcp", "-j", "TPROXY",
+			"--tproxy-mark", sidecarTproxyMark, "--on-port", sidecarInboundPort},
+
+		{"iptables", "-t", "mangle", "-N", "FV_WL_INBOUND"},
+		{"iptables", "-t", "mangle", "-A", "PREROUTING", "-p", "tcp", "-j", "FV_WL_INBOUND"},
+		{"iptables", "-t", "mangle", "-A", "FV_WL_INBOUND", "-m", "socket", "-j", "FV_WL_DIVERT"},
+
+		{"iptables", "-t", "mangle", "-N", "FV_WL_OUTPUT"},
+		{"iptables", "-t", "mangle", "-A", "OUTPUT", "-p", "tcp", "-j", "FV_WL_OUTPUT"},
+		{"iptables", "-t", "mangle", "-A", "FV_WL_OUTPUT", "-m", "owner", "--uid-owner", cfg.uid, "-j", "RETURN"},
+		{"iptables", "-t", "mangle", "-A", "FV_WL_OUTPUT", "-m", "owner", "--gid-owner", cfg.gid, "-j", "RETURN"},
+
+		// Packets marked above are routed back into the local stack rather than out the interface.
+		{"ip", "rule", "add", "fwmark", sidecarTproxyMark, "lookup", sidecarTproxyTable},
+		{"ip", "route", "add", "local", "0.0.0.0/0", "dev", "lo", "table", sidecarTproxyTable},
+	}
+	for _, port := range cfg.excludeInboundPorts {
+		cmds = append(cmds, []string{"iptables", "-t", "mangle", "-A", "FV_WL_INBOUND", "-p", "tcp", "--dport", port, "-j", "RETURN"})
+	}
+	cmds = append(cmds, []string{"iptables", "-t", "mangle", "-A", "FV_WL_INBOUND", "-p", "tcp", "-j", "FV_WL_TPROXY"})
+
+	for _, cidr := range cfg.excludeOutboundCIDRs {
+		cmds = append(cmds, []string{"iptables", "-t", "mangle", "-A", "FV_WL_OUTPUT", "-d", cidr, "-j", "RETURN"})
+	}
+	for _, cidr := range cfg.includeOutboundCIDRs {
+		cmds = append(cmds, []string{"iptables", "-t", "mangle", "-A", "FV_WL_OUTPUT", "-d", cidr, "-j", "MARK", "--set-mark", sidecarTproxyMark})
+	}
+
 	for _, cmd := range cmds {
 		if err := utils.RunCommand(cmd[0], cmd[1:]...); err != nil {
 			return err
@@ -25,15 +25,87 @@ import (
 	"github.com/projectcalico/calico/go/felix/jitter"
 )
 
+// ConditionType identifies one subsystem's contribution to an endpoint's
+// overall status, in the same spirit as Kubernetes' NodeCondition/PodCondition.
+type ConditionType string
+
+const (
+	ConditionPolicyProgrammed ConditionType = "PolicyProgrammed"
+	ConditionRoutesProgrammed ConditionType = "RoutesProgrammed"
+	ConditionIPSetsInSync     ConditionType = "IPSetsInSync"
+	// ConditionReady's Status drives the legacy up/down/error string that we
+	// keep writing for old clients.  It is set by legacy status updates or
+	// by a ConditionUpdate that carries it; it is not computed from the
+	// other conditions.
+	ConditionReady ConditionType = "Ready"
+)
+
+type ConditionStatus string
+
+const (
+	ConditionTrue    ConditionStatus = "True"
+	ConditionFalse   ConditionStatus = "False"
+	ConditionUnknown ConditionStatus = "Unknown"
+)
+
+// Condition is one entry in an endpoint's status, following the same
+// {Type, Status, Reason, Message, LastTransitionTime} shape Kubernetes uses
+// for node/pod conditions.
+type Condition struct {
+	Type               ConditionType
+	Status             ConditionStatus
+	Reason             string
+	Message            string
+	LastTransitionTime time.Time
+}
+
+// endpointConditions is the set of conditions currently known for one
+// endpoint, keyed by type so that applying a delta for one subsystem doesn't
+// disturb the others.
+type endpointConditions map[ConditionType]Condition
+
+// legacyStatus derives the old single-string status ("up"/"down"/"error")
+// from the Ready condition, for datastore backward compatibility.
+func (ec endpointConditions) legacyStatus() string {
+	ready, ok := ec[ConditionReady]
+	if !ok {
+		return ""
+	}
+	switch ready.Status {
+	case ConditionTrue:
+		return "up"
+	case ConditionFalse:
+		return "down"
+	default:
+		return "error"
+	}
+}
+
+// ConditionUpdate is a per-condition delta for one endpoint.  It's carried on
+// the same endpointUpdates channel as the legacy proto.*StatusUpdate
+// messages so that callers can migrate to structured conditions
+// incrementally; multiple updates for the same endpoint ID that arrive
+// before the next write are coalesced into a single datastore write.
+type ConditionUpdate struct {
+	ID         model.Key
+	Conditions []Condition
+}
+
+// ConditionRemove clears all known conditions (and hence the status entry)
+// for an endpoint, e.g. because it was removed from the datamodel.
+type ConditionRemove struct {
+	ID model.Key
+}
+
 type EndpointStatusReporter struct {
-	hostname           string
-	endpointUpdates    <-chan interface{}
-	inSync             <-chan bool
-	datastore          api.Client
-	epStatusIDToStatus map[model.Key]string
-	dirtyStatIDs       set.Set
-	reportingDelay     time.Duration
-	resyncInterval     time.Duration
+	hostname              string
+	endpointUpdates       <-chan interface{}
+	inSync                <-chan bool
+	datastore             api.Client
+	epStatusIDToConditions map[model.Key]endpointConditions
+	dirtyStatIDs          set.Set
+	reportingDelay        time.Duration
+	resyncInterval        time.Duration
 }
 
 func NewEndpointStatusReporter(hostname string,
@@ -43,14 +115,14 @@ func NewEndpointStatusReporter(hostname string,
 	reportingDelay time.Duration,
 	resyncInterval time.Duration) *EndpointStatusReporter {
 	return &EndpointStatusReporter{
-		hostname:           hostname,
-		endpointUpdates:    endpointUpdates,
-		datastore:          datastore,
-		inSync:             inSync,
-		epStatusIDToStatus: make(map[model.Key]string),
-		dirtyStatIDs:       set.New(),
-		reportingDelay:     reportingDelay,
-		resyncInterval:     resyncInterval,
+		hostname:               hostname,
+		endpointUpdates:        endpointUpdates,
+		datastore:              datastore,
+		inSync:                 inSync,
+		epStatusIDToConditions: make(map[model.Key]endpointConditions),
+		dirtyStatIDs:           set.New(),
+		reportingDelay:         reportingDelay,
+		resyncInterval:         resyncInterval,
 	}
 }
 
@@ -83,46 +155,7 @@ func (esr *EndpointStatusReporter) loopHandlingEndpointStatusUpdates() {
 			log.Debug("Datamodel in sync, enabling status resync")
 			datamodelInSync = true
 		case msg := <-esr.endpointUpdates:
-			var statID model.Key
-			var status string
-			switch msg := msg.(type) {
-			case *proto.WorkloadEndpointStatusUpdate:
-				statID = model.WorkloadEndpointStatusKey{
-					Hostname:       esr.hostname,
-					OrchestratorID: msg.Id.OrchestratorId,
-					WorkloadID:     msg.Id.WorkloadId,
-					EndpointID:     msg.Id.EndpointId,
-				}
-				status = msg.Status.Status
-			case *proto.WorkloadEndpointStatusRemove:
-				statID = model.WorkloadEndpointStatusKey{
-					Hostname:       esr.hostname,
-					OrchestratorID: msg.Id.OrchestratorId,
-					WorkloadID:     msg.Id.WorkloadId,
-					EndpointID:     msg.Id.EndpointId,
-				}
-			case *proto.HostEndpointStatusUpdate:
-				statID = model.HostEndpointStatusKey{
-					Hostname:   esr.hostname,
-					EndpointID: msg.Id.EndpointId,
-				}
-				status = msg.Status.Status
-			case *proto.HostEndpointStatusRemove:
-				statID = model.HostEndpointStatusKey{
-					Hostname:   esr.hostname,
-					EndpointID: msg.Id.EndpointId,
-				}
-			default:
-				log.Fatalf("Unexpected message: %#v", msg)
-			}
-			if esr.epStatusIDToStatus[statID] != status {
-				if status != "" {
-					esr.epStatusIDToStatus[statID] = status
-				} else {
-					delete(esr.epStatusIDToStatus, statID)
-				}
-				esr.dirtyStatIDs.Add(statID)
-			}
+			esr.handleUpdate(msg)
 		}
 
 		if datamodelInSync && resyncRequested {
@@ -140,8 +173,7 @@ func (esr *EndpointStatusReporter) loopHandlingEndpointStatusUpdates() {
 				return set.StopIteration
 			})
 
-			err := esr.writeEndpointStatus(statID,
-				esr.epStatusIDToStatus[statID])
+			err := esr.writeEndpointStatus(statID, esr.epStatusIDToConditions[statID])
 			if err == nil {
 				log.Debugf(
 					"Write successful, discarding %v from dirty set",
@@ -154,6 +186,104 @@ func (esr *EndpointStatusReporter) loopHandlingEndpointStatusUpdates() {
 	}
 }
 
+// handleUpdate applies one message from the endpointUpdates channel,
+// updating the in-memory condition set for the affected endpoint and
+// marking it dirty if anything actually changed.  Several condition
+// changes for the same endpoint that arrive before the next rate-limited
+// write collapse into that single write, same as the legacy single-string
+// status did.
+func (esr *EndpointStatusReporter) handleUpdate(msg interface{}) {
+	var statID model.Key
+	var newConditions []Condition
+	remove := false
+
+	switch msg := msg.(type) {
+	case *proto.WorkloadEndpointStatusUpdate:
+		statID = model.WorkloadEndpointStatusKey{
+			Hostname:       esr.hostname,
+			OrchestratorID: msg.Id.OrchestratorId,
+			WorkloadID:     msg.Id.WorkloadId,
+			EndpointID:     msg.Id.EndpointId,
+		}
+		newConditions = conditionsFromLegacyStatus(msg.Status.Status)
+	case *proto.WorkloadEndpointStatusRemove:
+		statID = model.WorkloadEndpointStatusKey{
+			Hostname:       esr.hostname,
+			OrchestratorID: msg.Id.OrchestratorId,
+			WorkloadID:     msg.Id.WorkloadId,
+			EndpointID:     msg.Id.EndpointId,
+		}
+		remove = true
+	case *proto.HostEndpointStatusUpdate:
+		statID = model.HostEndpointStatusKey{
+			Hostname:   esr.hostname,
+			EndpointID: msg.Id.EndpointId,
+		}
+		newConditions = conditionsFromLegacyStatus(msg.Status.Status)
+	case *proto.HostEndpointStatusRemove:
+		statID = model.HostEndpointStatusKey{
+			Hostname:   esr.hostname,
+			EndpointID: msg.Id.EndpointId,
+		}
+		remove = true
+	case ConditionUpdate:
+		statID = msg.ID
+		newConditions = msg.Conditions
+	case ConditionRemove:
+		statID = msg.ID
+		remove = true
+	default:
+		log.Fatalf("Unexpected message: %#v", msg)
+	}
+
+	if remove {
+		if _, ok := esr.epStatusIDToConditions[statID]; ok {
+			delete(esr.epStatusIDToConditions, statID)
+			esr.dirtyStatIDs.Add(statID)
+		}
+		return
+	}
+
+	existing := esr.epStatusIDToConditions[statID]
+	changed := false
+	for _, c := range newConditions {
+		if existing == nil {
+			existing = endpointConditions{}
+		}
+		if prev, ok := existing[c.Type]; !ok || prev.Status != c.Status || prev.Reason != c.Reason || prev.Message != c.Message {
+			existing[c.Type] = c
+			changed = true
+		}
+	}
+	if changed {
+		esr.epStatusIDToConditions[statID] = existing
+		esr.dirtyStatIDs.Add(statID)
+	}
+}
+
+// conditionsFromLegacyStatus maps the old "up"/"down"/"error" strings onto a
+// single Ready condition, so that callers who haven't been migrated to
+// structured conditions yet keep working unchanged.
+func conditionsFromLegacyStatus(status string) []Condition {
+	now := time.Now()
+	var cs ConditionStatus
+	switch status {
+	case "up":
+		cs = ConditionTrue
+	case "down":
+		cs = ConditionFalse
+	default:
+		cs = ConditionUnknown
+	}
+	return []Condition{{
+		Type:               ConditionReady,
+		Status:             cs,
+		Reason:             "LegacyStatus",
+		Message:            status,
+		LastTransitionTime: now,
+	}}
+}
+
 func (esr *EndpointStatusReporter) attemptResync() {
 	wlListOpts := model.WorkloadEndpointStatusListOptions{
 		Hostname: esr.hostname,
@@ -170,7 +300,7 @@ func (esr *EndpointStatusReporter) attemptResync() {
 			esr.dirtyStatIDs.Add(kv.Key)
 		} else {
 			status := kv.Value.(model.WorkloadEndpointStatus).Status
-			if status != esr.epStatusIDToStatus[kv.Key] {
+			if status != esr.epStatusIDToConditions[kv.Key].legacyStatus() {
 				log.Debugf("Found out-of sync endpoint status: %v", kv.Key)
 				esr.dirtyStatIDs.Add(kv.Key)
 			}
@@ -192,7 +322,7 @@ func (esr *EndpointStatusReporter) attemptResync() {
 			esr.dirtyStatIDs.Add(kv.Key)
 		} else {
 			status := kv.Value.(model.HostEndpointStatus).Status
-			if status != esr.epStatusIDToStatus[kv.Key] {
+			if status != esr.epStatusIDToConditions[kv.Key].legacyStatus() {
 				log.Debugf("Found out-of sync endpoint status: %v", kv.Key)
 				esr.dirtyStatIDs.Add(kv.Key)
 			}
@@ -200,10 +330,15 @@ func (esr *EndpointStatusReporter) attemptResync() {
 	}
 }
 
-func (esr *EndpointStatusReporter) writeEndpointStatus(epID model.Key, status string) (err error) {
+// writeEndpointStatus writes the current conditions for one endpoint.  The
+// datastore model still only has room for the legacy status string, so we
+// derive it from the Ready condition; the richer per-condition detail is
+// only available in-memory/via logs for now.
+func (esr *EndpointStatusReporter) writeEndpointStatus(epID model.Key, conditions endpointConditions) (err error) {
 	kv := model.KVPair{Key: epID}
+	status := conditions.legacyStatus()
 	if status != "" {
-		log.Debugf("Writing endpoint status for %v: %v", epID, status)
+		log.WithField("conditions", conditions).Debugf("Writing endpoint status for %v: %v", epID, status)
 		switch epID.(type) {
 		case model.HostEndpointStatusKey:
 			kv.Value = model.HostEndpointStatus{status}
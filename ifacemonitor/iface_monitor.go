@@ -15,7 +15,10 @@
 package ifacemonitor
 
 import (
+	"net"
+	"net/netip"
 	"regexp"
+	"sync"
 	"syscall"
 	"time"
 
@@ -25,17 +28,10 @@ import (
 	"github.com/projectcalico/libcalico-go/lib/set"
 
 	"github.com/projectcalico/felix/ip"
+	"github.com/projectcalico/felix/kernelfeatures"
+	"github.com/projectcalico/felix/netmon"
 )
 
-type netlinkStub interface {
-	Subscribe(
-		linkUpdates chan netlink.LinkUpdate,
-		addrUpdates chan netlink.AddrUpdate,
-	) error
-	LinkList() ([]netlink.Link, error)
-	AddrList(link netlink.Link, family int) ([]netlink.Addr, error)
-}
-
 type State string
 
 const (
@@ -44,39 +40,115 @@ const (
 	StateDown    = "down"
 )
 
-type InterfaceStateCallback func(ifaceName string, ifaceState State, ifIndex int)
+type InterfaceStateCallback func(ifaceName string, ifaceState State, ifIndex int, info LinkInfo)
 type AddrStateCallback func(ifaceName string, addrs set.Set)
 
+// DeviceClass categorizes a link's Kind for consumers that want to treat, say, every VXLAN
+// device the same way without string-matching Kind themselves.
+type DeviceClass string
+
+const (
+	DeviceClassUnknown   DeviceClass = ""
+	DeviceClassVXLAN     DeviceClass = "vxlan"
+	DeviceClassIPIP      DeviceClass = "ipip"
+	DeviceClassWireguard DeviceClass = "wireguard"
+	DeviceClassVeth      DeviceClass = "veth"
+	DeviceClassBond      DeviceClass = "bond"
+	DeviceClassBridge    DeviceClass = "bridge"
+)
+
+func deviceClassForKind(kind string) DeviceClass {
+	switch DeviceClass(kind) {
+	case DeviceClassVXLAN, DeviceClassIPIP, DeviceClassWireguard, DeviceClassVeth, DeviceClassBond, DeviceClassBridge:
+		return DeviceClass(kind)
+	default:
+		return DeviceClassUnknown
+	}
+}
+
+// LinkInfo is the subset of netlink.LinkAttrs that downstream managers (BPF, VXLAN, IPIP,
+// host-endpoint policy) actually need, so they don't have to re-open netlink themselves just to
+// look up a MAC address or MTU that InterfaceMonitor already read off the wire. It's the zero
+// value when the interface it would describe no longer exists.
+type LinkInfo struct {
+	MAC         net.HardwareAddr
+	MTU         int
+	MasterIndex int
+	Kind        string
+	DeviceClass DeviceClass
+}
+
+func linkInfoFromLink(link netlink.Link) LinkInfo {
+	attrs := link.Attrs()
+	return LinkInfo{
+		MAC:         attrs.HardwareAddr,
+		MTU:         attrs.MTU,
+		MasterIndex: attrs.MasterIndex,
+		Kind:        link.Type(),
+		DeviceClass: deviceClassForKind(link.Type()),
+	}
+}
+
 type Config struct {
 	// List of interface names that dataplane receives no callbacks from them.
 	InterfaceExcludes []*regexp.Regexp
+
+	// FlapDampingDelay is how long filterUpdates waits before forwarding an address deletion,
+	// in case it's immediately followed by a matching add (a flap). Interfaces not matched by
+	// PerInterfacePolicy use this. Defaults to 100ms if zero.
+	FlapDampingDelay time.Duration
+
+	// PerInterfacePolicy overrides FlapDampingDelay, and optionally suppresses address
+	// callbacks entirely, for interfaces matching Pattern. The first matching entry wins; an
+	// interface matching none of them falls back to FlapDampingDelay with no suppression.
+	PerInterfacePolicy []InterfacePolicy
+}
+
+// InterfacePolicy is one PerInterfacePolicy entry. For example, a WAN uplink running DHCP might
+// want a multi-second Delay, a veth/cali* pattern might want Delay: 0 so container churn is
+// forwarded immediately, and kube-ipvs0 might want Suppress: true.
+type InterfacePolicy struct {
+	Pattern  *regexp.Regexp
+	Delay    time.Duration
+	Suppress bool
 }
 type InterfaceMonitor struct {
 	Config
 
-	netlinkStub   netlinkStub
+	// netmon is the shared RTNETLINK subscription this monitor reads link/addr updates and
+	// resync state from, rather than opening (and resyncing) its own netlink socket.
+	netmon        *netmon.Monitor
 	resyncC       <-chan time.Time
 	upIfaces      map[string]int // Map from interface name to index.
 	StateCallback InterfaceStateCallback
 	AddrCallback  AddrStateCallback
 	ifaceName     map[int]string
 	ifaceAddrs    map[int]set.Set
+	ifaceLinkInfo map[int]LinkInfo
+
+	// mu guards everything above StateCallback/AddrCallback down to ifaceLinkInfo, plus
+	// subscribers, since Watch's bootstrap snapshot and the read loop's updates to that same
+	// state now run from different goroutines.
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
 }
 
-func New(config Config) *InterfaceMonitor {
-	// Interface monitor using the real netlink, and resyncing every 10 seconds.
+func New(config Config, nm *netmon.Monitor) *InterfaceMonitor {
+	// Interface monitor resyncing every 10 seconds, against the shared netmon.Monitor.
 	resyncTicker := time.NewTicker(10 * time.Second)
-	return NewWithStubs(config, &netlinkReal{}, resyncTicker.C)
+	return NewWithResyncChan(config, nm, resyncTicker.C)
 }
 
-func NewWithStubs(config Config, netlinkStub netlinkStub, resyncC <-chan time.Time) *InterfaceMonitor {
+func NewWithResyncChan(config Config, nm *netmon.Monitor, resyncC <-chan time.Time) *InterfaceMonitor {
 	return &InterfaceMonitor{
-		Config:      config,
-		netlinkStub: netlinkStub,
-		resyncC:     resyncC,
-		upIfaces:    map[string]int{},
-		ifaceName:   map[int]string{},
-		ifaceAddrs:  map[int]set.Set{},
+		Config:        config,
+		netmon:        nm,
+		resyncC:       resyncC,
+		upIfaces:      map[string]int{},
+		ifaceName:     map[int]string{},
+		ifaceAddrs:    map[int]set.Set{},
+		ifaceLinkInfo: map[int]LinkInfo{},
+		subscribers:   map[*subscriber]struct{}{},
 	}
 }
 
@@ -85,25 +157,70 @@ func IsInterfacePresent(name string) bool {
 	return link != nil
 }
 
+// ReloadConfig replaces m's Config, including InterfaceExcludes, FlapDampingDelay and
+// PerInterfacePolicy, picking it up on the next update filterUpdates processes.
+func (m *InterfaceMonitor) ReloadConfig(config Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Config = config
+}
+
+// policyFor returns the flap-damping delay and suppression for ifaceName, from the first
+// matching PerInterfacePolicy entry, or FlapDampingDelay (defaulted) with no suppression if
+// none match.
+func (m *InterfaceMonitor) policyFor(ifaceName string) (delay time.Duration, suppress bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range m.PerInterfacePolicy {
+		if p.Pattern.MatchString(ifaceName) {
+			return p.Delay, p.Suppress
+		}
+	}
+	if m.FlapDampingDelay != 0 {
+		return m.FlapDampingDelay, false
+	}
+	return flapDampingDelay, false
+}
+
+// ifaceNameForIndex resolves ifIndex via the shared netmon link cache -- filterUpdates runs on
+// its own goroutine and only ever sees ifindexes, so it can't read m.ifaceName, which is only
+// safe to touch under m.mu from the read loop's goroutine.
+func (m *InterfaceMonitor) ifaceNameForIndex(ifIndex int) string {
+	if link, ok := m.netmon.Links.Get(ifIndex); ok {
+		return link.Attrs().Name
+	}
+	return ""
+}
+
 func (m *InterfaceMonitor) MonitorInterfaces() {
 	log.Info("Interface monitoring thread started.")
 
+	// Probe once, up front: whether this kernel recognises IFA_F_MANAGETEMPADDR tells us
+	// whether the addresses we're about to read off netlink can reliably be told apart from
+	// SLAAC privacy-extension addresses, which matters for the flap-damping/dedup logic in
+	// filterUpdates.
+	features := kernelfeatures.Get()
+	log.WithField("manageTempAddrAvailable", features.ManageTempAddrAvailable).Info(
+		"Checked kernel feature support before starting netlink read loop.")
+
 	updates := make(chan netlink.LinkUpdate, 10)
 	addrUpdates := make(chan netlink.AddrUpdate, 10)
-	if err := m.netlinkStub.Subscribe(updates, addrUpdates); err != nil {
-		log.WithError(err).Panic("Failed to subscribe to netlink stub")
-	}
+	m.netmon.SubscribeLinks(updates)
+	m.netmon.SubscribeAddrs(addrUpdates)
+	defer m.netmon.UnsubscribeLinks(updates)
+	defer m.netmon.UnsubscribeAddrs(addrUpdates)
+
 	filteredUpdates := make(chan netlink.LinkUpdate, 10)
 	filteredAddrUpdates := make(chan netlink.AddrUpdate, 10)
-	go filterUpdates(filteredAddrUpdates, addrUpdates, filteredUpdates, updates)
-	log.Info("Subscribed to netlink updates.")
+	go m.filterUpdates(filteredAddrUpdates, addrUpdates, filteredUpdates, updates)
+	log.Info("Subscribed to shared netmon updates.")
 
 	// Start of day, do a resync to notify all our existing interfaces.  We also do periodic
-	// resyncs because it's not clear what the ordering guarantees are for our netlink
-	// subscription vs a list operation as used by resync().
+	// resyncs because it's not clear what the ordering guarantees are for our netmon
+	// subscription vs the netmon.Links/Addrs snapshot used by resync().
 	err := m.resync()
 	if err != nil {
-		log.WithError(err).Panic("Failed to read link states from netlink.")
+		log.WithError(err).Panic("Failed to read link states from netmon.")
 	}
 
 readLoop:
@@ -132,23 +249,34 @@ readLoop:
 			log.Debug("Resync trigger")
 			err := m.resync()
 			if err != nil {
-				log.WithError(err).Panic("Failed to read link states from netlink.")
+				log.WithError(err).Panic("Failed to read link states from netmon.")
 			}
 		}
 	}
-	log.Panic("Failed to read events from Netlink.")
+	log.Panic("Failed to read events from netmon.")
 }
 
 const flapDampingDelay = 100 * time.Millisecond
 
 // filterUpdates filters out updates that occur when IPs are quickly removed and re-added.
-// Some DHCP clients flap the IP during an IP renewal, for example.
+// Some DHCP clients flap the IP during an IP renewal, for example. It also drops a NEWADDR for
+// an address it already believes is present on that interface: the kernel repeats these during
+// DAD retries and SLAAC preferred/valid-lifetime refreshes, and forwarding every repeat would
+// bounce a spurious AddrCallback (and downstream route reprogramming) up for no actual change.
 //
 // Algorithm:
 // * Maintain a queue of link and address updates per interface.
-// * When we see a potential flap (i.e. an IP deletion), defer processing the queue for a while.
+// * When we see a potential flap (i.e. an IP deletion), defer processing the queue for a while,
+//   using m.policyFor(ifaceName)'s delay rather than a single fixed delay for every interface.
 // * If the flap resolves itself (i.e. the IP is added back), suppress the IP deletion.
-func filterUpdates(addrOutC chan<- netlink.AddrUpdate, addrInC <-chan netlink.AddrUpdate,
+// * Maintain a per-ifindex cache of addresses already forwarded as present; drop a NEWADDR for
+//   an address already in that cache, unless a DELADDR for the same address is still queued,
+//   before it even reaches the flap-damping queue. The cache
+//   entry is set/cleared as its address's NEWADDR/DELADDR is actually forwarded, and the whole
+//   per-ifindex cache is dropped on RTM_DELLINK so it can't leak onto a recycled ifindex.
+// * Drop an address update immediately, before it reaches the queue at all, for any interface
+//   whose PerInterfacePolicy has Suppress set.
+func (m *InterfaceMonitor) filterUpdates(addrOutC chan<- netlink.AddrUpdate, addrInC <-chan netlink.AddrUpdate,
 	linkOutC chan<- netlink.LinkUpdate, linkInC <-chan netlink.LinkUpdate) {
 
 	log.Debug("filterUpdates: starting")
@@ -160,24 +288,84 @@ func filterUpdates(addrOutC chan<- netlink.AddrUpdate, addrInC <-chan netlink.Ad
 	}
 
 	updatesByIfaceIdx := map[int][]timestampedUpd{}
+	knownAddrsByIfaceIdx := map[int]map[netip.Addr]bool{}
+
+	rememberAddr := func(idx int, addr netip.Addr) {
+		addrs, ok := knownAddrsByIfaceIdx[idx]
+		if !ok {
+			addrs = map[netip.Addr]bool{}
+			knownAddrsByIfaceIdx[idx] = addrs
+		}
+		addrs[addr] = true
+	}
+	forgetAddr := func(idx int, addr netip.Addr) {
+		delete(knownAddrsByIfaceIdx[idx], addr)
+	}
+	hasQueuedDelete := func(upds []timestampedUpd, addr net.IPNet) bool {
+		for _, upd := range upds {
+			if oldAddrUpd, ok := upd.Update.(netlink.AddrUpdate); ok && !oldAddrUpd.NewAddr &&
+				ip.IPNetsEqual(&oldAddrUpd.LinkAddress, &addr) {
+				return true
+			}
+		}
+		return false
+	}
+	rememberOrForgetAddr := func(u netlink.AddrUpdate) {
+		addr, ok := netip.AddrFromSlice(u.LinkAddress.IP)
+		if !ok {
+			return
+		}
+		addr = addr.Unmap()
+		if u.NewAddr {
+			rememberAddr(u.LinkIndex, addr)
+		} else {
+			forgetAddr(u.LinkIndex, addr)
+		}
+	}
 
 	for {
 		select {
 		case linkUpd := <-linkInC:
 			idx := linkUpd.Index
+			if linkUpd.Header.Type == syscall.RTM_DELLINK {
+				log.WithField("ifIndex", idx).Debug("filterUpdates: link deleted, dropping its address cache.")
+				delete(knownAddrsByIfaceIdx, int(idx))
+			}
 			if len(updatesByIfaceIdx[int(idx)]) == 0 {
 				log.Debug("filterUpdates: link change with empty queue, short circuit.")
 				linkOutC <- linkUpd
 				continue
 			}
+			delay, _ := m.policyFor(m.ifaceNameForIndex(int(idx)))
 			updatesByIfaceIdx[int(idx)] = append(updatesByIfaceIdx[int(idx)],
 				timestampedUpd{
-					ReadyAt: time.Now().Add(flapDampingDelay),
+					ReadyAt: time.Now().Add(delay),
 					Update:  linkUpd,
 				})
 		case addrUpd := <-addrInC:
 			log.WithField("update", addrUpd).Debug("filterUpdates: got new update")
 			idx := addrUpd.LinkIndex
+			delay, suppress := m.policyFor(m.ifaceNameForIndex(idx))
+			if suppress {
+				log.WithField("ifIndex", idx).Debug("filterUpdates: address callbacks suppressed for this interface, dropping.")
+				continue
+			}
+
+			if addrUpd.NewAddr {
+				if addr, ok := netip.AddrFromSlice(addrUpd.LinkAddress.IP); ok && knownAddrsByIfaceIdx[idx][addr.Unmap()] &&
+					!hasQueuedDelete(updatesByIfaceIdx[idx], addrUpd.LinkAddress) {
+					// The address is already forwarded as present and there's no pending deletion
+					// of it in the queue, so this really is just a repeat NEWADDR (DAD/SLAAC
+					// refresh) rather than a delete-then-re-add flap. If a delete were queued, we
+					// must fall through so the squash logic below cancels it instead of us
+					// swallowing the re-add and leaving the queued DELADDR to be reported as a
+					// permanent removal.
+					log.WithField("address", addrUpd.LinkAddress.String()).Debug(
+						"filterUpdates: address already known, dropping repeat NEWADDR.")
+					continue
+				}
+			}
+
 			oldUpds := updatesByIfaceIdx[idx]
 
 			var readyToSendTime time.Time
@@ -186,6 +374,7 @@ func filterUpdates(addrOutC chan<- netlink.AddrUpdate, addrInC <-chan netlink.Ad
 					// This is an add for a new IP and there's nothing else in the queue for this interface.
 					// Short circuit.
 					log.Debug("filterUpdates: add with empty queue, short circuit.")
+					rememberOrForgetAddr(addrUpd)
 					addrOutC <- addrUpd
 					continue
 				}
@@ -195,7 +384,7 @@ func filterUpdates(addrOutC chan<- netlink.AddrUpdate, addrInC <-chan netlink.Ad
 				readyToSendTime = time.Now()
 			} else {
 				log.Debug("filterUpdates: delete.")
-				readyToSendTime = time.Now().Add(flapDampingDelay)
+				readyToSendTime = time.Now().Add(delay)
 			}
 			upds := oldUpds[:0]
 			for _, upd := range oldUpds {
@@ -229,6 +418,7 @@ func filterUpdates(addrOutC chan<- netlink.AddrUpdate, addrInC <-chan netlink.Ad
 					log.WithField("update", firstUpd).Debug("filterUpdates: update ready to send.")
 					switch u := firstUpd.Update.(type) {
 					case netlink.AddrUpdate:
+						rememberOrForgetAddr(u)
 						addrOutC <- u
 					case netlink.LinkUpdate:
 						linkOutC <- u
@@ -277,6 +467,9 @@ func (m *InterfaceMonitor) isExcludedInterface(ifName string) bool {
 }
 
 func (m *InterfaceMonitor) handleNetlinkUpdate(update netlink.LinkUpdate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	attrs := update.Attrs()
 	linkAttrs := update.Link.Attrs()
 	if attrs == nil || linkAttrs == nil {
@@ -291,6 +484,9 @@ func (m *InterfaceMonitor) handleNetlinkUpdate(update netlink.LinkUpdate) {
 }
 
 func (m *InterfaceMonitor) handleNetlinkAddrUpdate(update netlink.AddrUpdate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	ifIndex := update.LinkIndex
 	if ifName, known := m.ifaceName[ifIndex]; known {
 		if m.isExcludedInterface(ifName) {
@@ -326,18 +522,24 @@ func (m *InterfaceMonitor) handleNetlinkAddrUpdate(update netlink.AddrUpdate) {
 
 	if exists {
 		if !m.ifaceAddrs[ifIndex].Contains(addr) {
+			oldAddrs := m.ifaceAddrs[ifIndex].Copy()
 			m.ifaceAddrs[ifIndex].Add(addr)
-			m.notifyIfaceAddrs(ifIndex)
+			m.notifyIfaceAddrs(ifIndex, oldAddrs)
 		}
 	} else {
 		if m.ifaceAddrs[ifIndex].Contains(addr) {
+			oldAddrs := m.ifaceAddrs[ifIndex].Copy()
 			m.ifaceAddrs[ifIndex].Discard(addr)
-			m.notifyIfaceAddrs(ifIndex)
+			m.notifyIfaceAddrs(ifIndex, oldAddrs)
 		}
 	}
 }
 
-func (m *InterfaceMonitor) notifyIfaceAddrs(ifIndex int) {
+// notifyIfaceAddrs invokes AddrCallback and publishes an AddrEvent to any Watch subscribers for
+// ifIndex's current address set, which must already reflect the change being notified. oldAddrs
+// is the address set (or nil) from before that change, used only to classify the AddrEvent's
+// kind and as its OldAddrs.
+func (m *InterfaceMonitor) notifyIfaceAddrs(ifIndex int, oldAddrs set.Set) {
 	log.WithField("ifIndex", ifIndex).Debug("notifyIfaceAddrs")
 	if name, known := m.ifaceName[ifIndex]; known {
 		log.WithField("ifIndex", ifIndex).Debug("Known interface")
@@ -348,6 +550,20 @@ func (m *InterfaceMonitor) notifyIfaceAddrs(ifIndex int) {
 			addrs = addrs.Copy()
 		}
 		m.AddrCallback(name, addrs)
+		m.publishAddrEvent(AddrEvent{kind: addrEventKind(oldAddrs, addrs), IfaceName: name, IfIndex: ifIndex, OldAddrs: oldAddrs, NewAddrs: addrs})
+	}
+}
+
+// addrEventKind classifies an address-set transition for publishAddrEvent; never Existing or
+// Idle, since those are reserved for a Watch subscription's initial snapshot.
+func addrEventKind(oldAddrs, newAddrs set.Set) EventKind {
+	switch {
+	case oldAddrs == nil && newAddrs != nil:
+		return EventAdded
+	case oldAddrs != nil && newAddrs == nil:
+		return EventRemoved
+	default:
+		return EventChanged
 	}
 }
 
@@ -384,14 +600,17 @@ func (m *InterfaceMonitor) storeAndNotifyLinkInner(ifaceExists bool, ifaceName s
 	ifIndex := attrs.Index
 	if ifaceExists {
 		m.ifaceName[ifIndex] = ifaceName
+		m.ifaceLinkInfo[ifIndex] = linkInfoFromLink(link)
 	} else {
 		if !m.isExcludedInterface(ifaceName) {
 			// for excluded interfaces, e.g. kube-ipvs0, we ignore all ip address changes.
 			log.Debug("Notify link non-existence to address callback consumers")
+			oldAddrs := m.ifaceAddrs[ifIndex]
 			delete(m.ifaceAddrs, ifIndex)
-			m.notifyIfaceAddrs(ifIndex)
+			m.notifyIfaceAddrs(ifIndex, oldAddrs)
 		}
 		delete(m.ifaceName, ifIndex)
+		delete(m.ifaceLinkInfo, ifIndex)
 	}
 
 	// We need the operstate of the interface; this is carried in the IFF_RUNNING flag.  The
@@ -400,15 +619,20 @@ func (m *InterfaceMonitor) storeAndNotifyLinkInner(ifaceExists bool, ifaceName s
 	rawFlags := attrs.RawFlags
 	ifaceIsUp := ifaceExists && rawFlags&syscall.IFF_RUNNING != 0
 	oldIfIndex, ifaceWasUp := m.upIfaces[ifaceName]
+	// Zero value (LinkInfo{}) if the interface no longer exists -- there's nothing left to
+	// report for it.
+	info := m.ifaceLinkInfo[ifIndex]
 	logCxt := log.WithField("ifaceName", ifaceName)
 	if ifaceIsUp && !ifaceWasUp {
 		logCxt.Debug("Interface now up")
 		m.upIfaces[ifaceName] = ifIndex
-		m.StateCallback(ifaceName, StateUp, ifIndex)
+		m.StateCallback(ifaceName, StateUp, ifIndex, info)
+		m.publishLinkEvent(LinkEvent{kind: EventAdded, IfaceName: ifaceName, IfIndex: ifIndex, OldState: StateUnknown, NewState: StateUp, Info: info})
 	} else if ifaceWasUp && !ifaceIsUp {
 		logCxt.Debug("Interface now down")
 		delete(m.upIfaces, ifaceName)
-		m.StateCallback(ifaceName, StateDown, oldIfIndex)
+		m.StateCallback(ifaceName, StateDown, oldIfIndex, info)
+		m.publishLinkEvent(LinkEvent{kind: EventRemoved, IfaceName: ifaceName, IfIndex: oldIfIndex, OldState: StateUp, NewState: StateDown, Info: info})
 	} else {
 		logCxt.WithField("ifaceIsUp", ifaceIsUp).Debug("Nothing to notify")
 	}
@@ -419,32 +643,28 @@ func (m *InterfaceMonitor) storeAndNotifyLinkInner(ifaceExists bool, ifaceName s
 	// will allow us to secure a Host Endpoint interface _before_ it comes up, and so eliminate
 	// a small window of insecurity.
 	if ifaceExists && !m.isExcludedInterface(ifaceName) {
-		// Notify address changes for non excluded interfaces.
+		// Notify address changes for non excluded interfaces, from netmon's cache rather than
+		// an AddrList call of our own.
 		newAddrs := set.New()
-		for _, family := range [2]int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
-			addrs, err := m.netlinkStub.AddrList(link, family)
-			if err != nil {
-				log.WithError(err).Warn("Netlink addr list operation failed.")
-			}
-			for _, addr := range addrs {
-				newAddrs.Add(addr.IPNet.IP.String())
-			}
+		for _, addr := range m.netmon.Addrs.Get(ifIndex) {
+			newAddrs.Add(addr.IPNet.IP.String())
 		}
 		if (m.ifaceAddrs[ifIndex] == nil) || !m.ifaceAddrs[ifIndex].Equals(newAddrs) {
+			oldAddrs := m.ifaceAddrs[ifIndex]
 			m.ifaceAddrs[ifIndex] = newAddrs
 
-			m.notifyIfaceAddrs(ifIndex)
+			m.notifyIfaceAddrs(ifIndex, oldAddrs)
 		}
 	}
 }
 
 func (m *InterfaceMonitor) resync() error {
-	log.Debug("Resyncing interface state.")
-	links, err := m.netlinkStub.LinkList()
-	if err != nil {
-		log.WithError(err).Warn("Netlink list operation failed.")
-		return err
-	}
+	log.Debug("Resyncing interface state from netmon.")
+	links := m.netmon.Links.List()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	currentIfaces := set.New()
 	for _, link := range links {
 		attrs := link.Attrs()
@@ -462,11 +682,18 @@ func (m *InterfaceMonitor) resync() error {
 			continue
 		}
 		log.WithField("ifaceName", name).Info("Spotted interface removal on resync.")
-		m.StateCallback(name, StateDown, ifIndex)
+		oldAddrs := m.ifaceAddrs[ifIndex]
+		// The interface no longer exists, so its LinkInfo is the zero value.
+		m.StateCallback(name, StateDown, ifIndex, LinkInfo{})
 		m.AddrCallback(name, nil)
+		m.publishLinkEvent(LinkEvent{kind: EventRemoved, IfaceName: name, IfIndex: ifIndex, OldState: StateUp, NewState: StateDown, Info: LinkInfo{}})
+		if oldAddrs != nil {
+			m.publishAddrEvent(AddrEvent{kind: EventRemoved, IfaceName: name, IfIndex: ifIndex, OldAddrs: oldAddrs, NewAddrs: nil})
+		}
 		delete(m.upIfaces, name)
 		delete(m.ifaceAddrs, ifIndex)
 		delete(m.ifaceName, ifIndex)
+		delete(m.ifaceLinkInfo, ifIndex)
 	}
 	log.Debug("Resync complete")
 	return nil
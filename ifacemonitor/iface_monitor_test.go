@@ -0,0 +1,209 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ifacemonitor
+
+import (
+	"net"
+	"regexp"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+
+	"github.com/projectcalico/felix/netmon"
+)
+
+const testTimeout = time.Second
+
+func newAddrUpdate(ifIndex int, cidr string, newAddr bool) netlink.AddrUpdate {
+	addrIP, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	ipNet.IP = addrIP
+	return netlink.AddrUpdate{LinkIndex: ifIndex, LinkAddress: *ipNet, NewAddr: newAddr}
+}
+
+// recvAddr waits up to testTimeout for an update on ch, returning ok=false on timeout.
+func recvAddr(ch <-chan netlink.AddrUpdate) (netlink.AddrUpdate, bool) {
+	select {
+	case u := <-ch:
+		return u, true
+	case <-time.After(testTimeout):
+		return netlink.AddrUpdate{}, false
+	}
+}
+
+// expectNoAddr fails the test if an update arrives on ch before a short grace period elapses.
+func expectNoAddr(t *testing.T, ch <-chan netlink.AddrUpdate) {
+	t.Helper()
+	select {
+	case u := <-ch:
+		t.Fatalf("expected no forwarded update, got %+v", u)
+	case <-time.After(2 * flapDampingDelay):
+	}
+}
+
+func startFilterUpdates() (addrIn chan netlink.AddrUpdate, addrOut chan netlink.AddrUpdate, linkIn chan netlink.LinkUpdate, linkOut chan netlink.LinkUpdate) {
+	return startFilterUpdatesWithConfig(Config{})
+}
+
+func startFilterUpdatesWithConfig(config Config) (addrIn chan netlink.AddrUpdate, addrOut chan netlink.AddrUpdate, linkIn chan netlink.LinkUpdate, linkOut chan netlink.LinkUpdate) {
+	addrIn = make(chan netlink.AddrUpdate)
+	addrOut = make(chan netlink.AddrUpdate)
+	linkIn = make(chan netlink.LinkUpdate)
+	linkOut = make(chan netlink.LinkUpdate)
+	m := &InterfaceMonitor{Config: config, netmon: netmon.New()}
+	go m.filterUpdates(addrOut, addrIn, linkOut, linkIn)
+	return
+}
+
+func TestFilterUpdates_DropsDADRetransmit(t *testing.T) {
+	addrIn, addrOut, _, _ := startFilterUpdates()
+
+	addrIn <- newAddrUpdate(5, "fe80::1/64", true)
+	if upd, ok := recvAddr(addrOut); !ok || !upd.NewAddr {
+		t.Fatalf("expected first NEWADDR to be forwarded, got %+v ok=%v", upd, ok)
+	}
+
+	// The kernel retransmits the same tentative address's NEWADDR repeatedly while DAD is in
+	// progress; none of the repeats should reach the consumer.
+	for i := 0; i < 3; i++ {
+		addrIn <- newAddrUpdate(5, "fe80::1/64", true)
+	}
+	expectNoAddr(t, addrOut)
+}
+
+func TestFilterUpdates_DropsSLAACRefresh(t *testing.T) {
+	addrIn, addrOut, _, _ := startFilterUpdates()
+
+	addrIn <- newAddrUpdate(7, "2001:db8::1/64", true)
+	if _, ok := recvAddr(addrOut); !ok {
+		t.Fatalf("expected initial NEWADDR to be forwarded")
+	}
+
+	// SLAAC periodically re-announces the same address to refresh its preferred/valid
+	// lifetime; that shouldn't look like a change to us.
+	time.Sleep(2 * flapDampingDelay)
+	addrIn <- newAddrUpdate(7, "2001:db8::1/64", true)
+	expectNoAddr(t, addrOut)
+}
+
+func TestFilterUpdates_ReAddAfterRealDeleteIsForwarded(t *testing.T) {
+	addrIn, addrOut, _, _ := startFilterUpdates()
+
+	addrIn <- newAddrUpdate(9, "10.0.0.1/32", true)
+	if _, ok := recvAddr(addrOut); !ok {
+		t.Fatalf("expected initial NEWADDR to be forwarded")
+	}
+
+	addrIn <- newAddrUpdate(9, "10.0.0.1/32", false)
+	upd, ok := recvAddr(addrOut)
+	if !ok || upd.NewAddr {
+		t.Fatalf("expected the DELADDR to be forwarded once flap damping elapsed, got %+v ok=%v", upd, ok)
+	}
+
+	// Once genuinely removed, a later NEWADDR for the same address is new information again,
+	// not a duplicate to suppress.
+	addrIn <- newAddrUpdate(9, "10.0.0.1/32", true)
+	if upd, ok := recvAddr(addrOut); !ok || !upd.NewAddr {
+		t.Fatalf("expected NEWADDR after a real delete to be forwarded, got %+v ok=%v", upd, ok)
+	}
+}
+
+func TestFilterUpdates_SuppressPolicyDropsAddr(t *testing.T) {
+	// ifaceNameForIndex can't resolve a name for an ifindex the test harness never populated
+	// into netmon's link cache, so it resolves to "", and a pattern matching everything
+	// (including "") is what's needed to exercise PerInterfacePolicy here.
+	cfg := Config{PerInterfacePolicy: []InterfacePolicy{{Pattern: regexp.MustCompile(".*"), Suppress: true}}}
+	addrIn, addrOut, _, _ := startFilterUpdatesWithConfig(cfg)
+
+	addrIn <- newAddrUpdate(13, "10.0.0.1/32", true)
+	expectNoAddr(t, addrOut)
+}
+
+func TestFilterUpdates_PerInterfaceZeroDelayForwardsDeletePromptly(t *testing.T) {
+	cfg := Config{FlapDampingDelay: time.Hour, PerInterfacePolicy: []InterfacePolicy{{Pattern: regexp.MustCompile(".*"), Delay: 0}}}
+	addrIn, addrOut, _, _ := startFilterUpdatesWithConfig(cfg)
+
+	addrIn <- newAddrUpdate(15, "10.0.0.1/32", true)
+	if _, ok := recvAddr(addrOut); !ok {
+		t.Fatalf("expected initial NEWADDR to be forwarded")
+	}
+
+	// The interface's zero-delay policy should override FlapDampingDelay, so the delete is
+	// forwarded promptly instead of only after an hour-long default would elapse.
+	addrIn <- newAddrUpdate(15, "10.0.0.1/32", false)
+	if upd, ok := recvAddr(addrOut); !ok || upd.NewAddr {
+		t.Fatalf("expected DELADDR to be forwarded promptly, got %+v ok=%v", upd, ok)
+	}
+}
+
+func TestFilterUpdates_ReAddWithinDampingWindowCancelsDelete(t *testing.T) {
+	addrIn, addrOut, _, _ := startFilterUpdates()
+
+	addrIn <- newAddrUpdate(17, "10.0.0.1/32", true)
+	if _, ok := recvAddr(addrOut); !ok {
+		t.Fatalf("expected initial NEWADDR to be forwarded")
+	}
+
+	// A delete-then-re-add flap, e.g. a DHCP renewal: the DELADDR is queued and damped, then the
+	// address comes straight back before the damping delay elapses. The known-address cache must
+	// not swallow the re-add as a "repeat NEWADDR" here, since doing so would let the queued
+	// DELADDR fall through on its own and get reported as a permanent removal.
+	addrIn <- newAddrUpdate(17, "10.0.0.1/32", false)
+	addrIn <- newAddrUpdate(17, "10.0.0.1/32", true)
+
+	upd, ok := recvAddr(addrOut)
+	if !ok || !upd.NewAddr {
+		t.Fatalf("expected the flap to resolve as a forwarded NEWADDR, not a removal, got %+v ok=%v", upd, ok)
+	}
+	expectNoAddr(t, addrOut)
+}
+
+type fakeLink struct {
+	attrs netlink.LinkAttrs
+}
+
+func (f *fakeLink) Attrs() *netlink.LinkAttrs { return &f.attrs }
+func (f *fakeLink) Type() string              { return "fake" }
+
+func TestFilterUpdates_ClearsAddrCacheOnLinkDelete(t *testing.T) {
+	addrIn, addrOut, linkIn, linkOut := startFilterUpdates()
+
+	addrIn <- newAddrUpdate(11, "10.0.0.1/32", true)
+	if _, ok := recvAddr(addrOut); !ok {
+		t.Fatalf("expected initial NEWADDR to be forwarded")
+	}
+
+	// filterUpdates keys link updates on the ifinfomsg header's index, and
+	// forwards them on an unbuffered channel that has to be drained.
+	linkIn <- netlink.LinkUpdate{
+		IfInfomsg: nl.IfInfomsg{IfInfomsg: unix.IfInfomsg{Index: 11}},
+		Header:    unix.NlMsghdr{Type: syscall.RTM_DELLINK},
+		Link:      &fakeLink{attrs: netlink.LinkAttrs{Index: 11}},
+	}
+	<-linkOut
+
+	// ifIndex 11 could now be reused by a brand new interface with the same address; that
+	// NEWADDR must not be mistaken for a dup of the deleted interface's address.
+	addrIn <- newAddrUpdate(11, "10.0.0.1/32", true)
+	if upd, ok := recvAddr(addrOut); !ok || !upd.NewAddr {
+		t.Fatalf("expected NEWADDR on a recycled ifindex to be forwarded, got %+v ok=%v", upd, ok)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ifacemonitor
+
+import (
+	"context"
+	"sync"
+
+	"github.com/projectcalico/libcalico-go/lib/set"
+)
+
+// EventKind categorizes an Event. Existing and Idle only ever appear
+// during a subscription's initial snapshot (modeled on the fuchsia.net.
+// interfaces watcher pattern): every interface known at the time of the
+// Watch call is sent as one Existing event, then a single Idle event
+// marks the end of the snapshot, and only Added/Changed/Removed events
+// follow after that.
+type EventKind int
+
+const (
+	EventExisting EventKind = iota
+	EventIdle
+	EventAdded
+	EventChanged
+	EventRemoved
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventExisting:
+		return "Existing"
+	case EventIdle:
+		return "Idle"
+	case EventAdded:
+		return "Added"
+	case EventChanged:
+		return "Changed"
+	case EventRemoved:
+		return "Removed"
+	}
+	return "Unknown"
+}
+
+// Event is one record sent over a Watch subscription's channel. Idle is
+// always the sentinel value IdleEvent; every other Event is either a
+// LinkEvent or an AddrEvent.
+type Event interface {
+	Kind() EventKind
+}
+
+type idleEvent struct{}
+
+func (idleEvent) Kind() EventKind { return EventIdle }
+
+// IdleEvent is the single value a Watch subscription sends once its
+// initial snapshot of Existing LinkEvent/AddrEvent pairs is complete.
+var IdleEvent Event = idleEvent{}
+
+// LinkEvent reports ifaceName/ifIndex's up/down state as of NewState,
+// plus its LinkInfo (MAC, MTU, master ifindex, kind/device class) as of
+// that same observation. OldState is StateUnknown for an Existing or
+// Added event, since there's no prior state to report.
+type LinkEvent struct {
+	kind      EventKind
+	IfaceName string
+	IfIndex   int
+	OldState  State
+	NewState  State
+	Info      LinkInfo
+}
+
+func (e LinkEvent) Kind() EventKind { return e.kind }
+
+// AddrEvent reports ifaceName/ifIndex's current address set as of
+// NewAddrs. OldAddrs is nil for an Existing or Added event.
+type AddrEvent struct {
+	kind      EventKind
+	IfaceName string
+	IfIndex   int
+	OldAddrs  set.Set
+	NewAddrs  set.Set
+}
+
+func (e AddrEvent) Kind() EventKind { return e.kind }
+
+// subscriber is one Watch call's delivery pipeline. Events are appended
+// to queue (by whichever goroutine generates them, always while holding
+// InterfaceMonitor.mu) and drained, strictly in order, by run's
+// goroutine onto out -- so a consumer that's slow to read from Events
+// never blocks event generation for other subscribers or for the
+// monitor's own read loop, and never observes two events out of the
+// order they were generated in.
+type subscriber struct {
+	out chan Event
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []Event
+	closed bool
+}
+
+func newSubscriber() *subscriber {
+	s := &subscriber{out: make(chan Event)}
+	s.cond = sync.NewCond(&s.mu)
+	go s.run()
+	return s
+}
+
+func (s *subscriber) push(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.queue = append(s.queue, ev)
+	s.cond.Signal()
+}
+
+func (s *subscriber) run() {
+	defer close(s.out)
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		ev := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		s.out <- ev
+	}
+}
+
+// stop marks s closed; run keeps draining any already-queued events to
+// out before it exits and closes out.
+func (s *subscriber) stop() {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Signal()
+	s.mu.Unlock()
+}
+
+// Watch subscribes to the monitor's interface/address events. The
+// returned channel first receives one Existing LinkEvent and, if an
+// address set is known for the interface (it may be empty), one Existing
+// AddrEvent per interface known at the time of the call, then a single
+// IdleEvent, then a live
+// stream of Added/Changed/Removed events as they happen. The channel is
+// closed once ctx is done; callers that no longer want to watch should
+// cancel ctx rather than relying on draining the channel.
+//
+// Unlike StateCallback/AddrCallback, Watch needs no external
+// resync-and-diff logic to bootstrap: the Existing snapshot and the live
+// stream are generated under the same lock, so no update can be missed
+// or double-counted between them.
+func (m *InterfaceMonitor) Watch(ctx context.Context) (<-chan Event, error) {
+	m.mu.Lock()
+	sub := newSubscriber()
+	m.subscribers[sub] = struct{}{}
+	for ifIndex, ifaceName := range m.ifaceName {
+		_, isUp := m.upIfaces[ifaceName]
+		state := StateDown
+		if isUp {
+			state = StateUp
+		}
+		sub.push(LinkEvent{kind: EventExisting, IfaceName: ifaceName, IfIndex: ifIndex, NewState: state, Info: m.ifaceLinkInfo[ifIndex]})
+		if addrs, ok := m.ifaceAddrs[ifIndex]; ok && addrs != nil {
+			sub.push(AddrEvent{kind: EventExisting, IfaceName: ifaceName, IfIndex: ifIndex, NewAddrs: addrs.Copy()})
+		}
+	}
+	sub.push(IdleEvent)
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		delete(m.subscribers, sub)
+		m.mu.Unlock()
+		sub.stop()
+	}()
+
+	return sub.out, nil
+}
+
+// publishLinkEvent pushes ev to every current subscriber. The caller
+// must hold m.mu.
+func (m *InterfaceMonitor) publishLinkEvent(ev LinkEvent) {
+	for sub := range m.subscribers {
+		sub.push(ev)
+	}
+}
+
+// publishAddrEvent pushes ev to every current subscriber. The caller
+// must hold m.mu.
+func (m *InterfaceMonitor) publishAddrEvent(ev AddrEvent) {
+	for sub := range m.subscribers {
+		sub.push(ev)
+	}
+}
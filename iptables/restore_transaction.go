@@ -0,0 +1,213 @@
+// Copyright (c) 2019 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	gaugeRestoreTime = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "felix_iptables_restore_seconds",
+		Help: "Time taken for a single iptables-restore transaction to apply.",
+	})
+	gaugeEditScriptSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "felix_iptables_restore_edit_script_size",
+		Help: "Number of -D/-R/-I/-A operations in an iptables-restore transaction.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(gaugeRestoreTime)
+	prometheus.MustRegister(gaugeEditScriptSize)
+}
+
+// commentHashRegexp extracts the chained rule hash that we stash in every
+// rendered rule via "-m comment --comment <hash>".
+var commentHashRegexp = regexp.MustCompile(`--comment\s+"?([A-Za-z0-9_-]{16})(?:[: ]|"$|$)`)
+
+// tableLocks serialises writers per-table so that two concurrent callers
+// don't race to restore the same table.
+var tableLocks sync.Map // table name -> *sync.Mutex
+
+func lockForTable(table string) *sync.Mutex {
+	l, _ := tableLocks.LoadOrStore(table, &sync.Mutex{})
+	return l.(*sync.Mutex)
+}
+
+// Transaction computes and applies a diff between a set of desired
+// Chains and whatever iptables-restore reports is currently loaded, using the
+// per-rule hashes that Chain.RuleHashes already computes.  All edits for one
+// table are applied in a single "iptables-restore --noflush" invocation so
+// that a churn burst collapses into one or two exec calls instead of one per
+// rule.
+type Transaction struct {
+	Table    string
+	Features *Features
+
+	// restoreCmd lets tests substitute a fake iptables-restore; defaults to
+	// the real binary.
+	restoreCmd func(table string, noFlush bool) *exec.Cmd
+}
+
+// NewTransaction creates a Transaction targeting the given table (e.g.
+// "filter", "nat", "mangle").
+func NewTransaction(table string, features *Features) *Transaction {
+	return &Transaction{
+		Table:    table,
+		Features: features,
+		restoreCmd: func(table string, noFlush bool) *exec.Cmd {
+			args := []string{"--noflush"}
+			if !noFlush {
+				args = []string{}
+			}
+			args = append(args, "-T", table)
+			return exec.Command("iptables-restore", args...)
+		},
+	}
+}
+
+// edit is one line of an iptables-restore input file.
+type edit struct {
+	op   string // "-D" or "-A" from diff; "-F" (a chain line that flushes the chain) or "-A" from fullResync
+	line string
+}
+
+// Apply reads the actual state of the table, diffs it against desired by
+// rule hash, and pushes a single restore batch of the deletes and appends
+// that diff produces to reconcile them.  On failure it retries
+// once with a full chain re-sync (flush + re-add everything) rather than
+// trying to patch up a partially-applied diff.
+func (t *Transaction) Apply(desired []*Chain, actualRuleIteration func() (map[string][]string, error)) error {
+	start := time.Now()
+	defer func() {
+		gaugeRestoreTime.Observe(time.Since(start).Seconds())
+	}()
+
+	lock := lockForTable(t.Table)
+	lock.Lock()
+	defer lock.Unlock()
+
+	actual, err := actualRuleIteration()
+	if err != nil {
+		return fmt.Errorf("failed to read current iptables state for table %s: %w", t.Table, err)
+	}
+
+	edits := t.diff(desired, actual)
+	gaugeEditScriptSize.Observe(float64(len(edits)))
+
+	if err := t.restore(edits, true); err != nil {
+		log.WithError(err).WithField("table", t.Table).Warn(
+			"iptables-restore failed; retrying with full re-sync")
+		fullEdits := t.fullResync(desired)
+		if err2 := t.restore(fullEdits, false); err2 != nil {
+			return fmt.Errorf("iptables-restore retry also failed: %w", err2)
+		}
+	}
+	return nil
+}
+
+// diff computes an edit script to turn actual (chain name -> rule hashes, in
+// order) into desired.  It keeps the longest common prefix of hashes, deletes
+// every existing rule after it and appends every desired rule after it; it
+// emits no -R or -I edits, so a change early in a chain rewrites the rest of
+// that chain.
+func (t *Transaction) diff(desired []*Chain, actual map[string][]string) []edit {
+	var edits []edit
+	for _, chain := range desired {
+		wantHashes := chain.RuleHashes(t.Features)
+		haveHashes := actual[chain.Name]
+
+		// Longest common prefix: rules up to here don't need touching.
+		common := 0
+		for common < len(wantHashes) && common < len(haveHashes) && wantHashes[common] == haveHashes[common] {
+			common++
+		}
+
+		// Anything left over in the existing chain beyond the common prefix
+		// must go, highest rule number first so earlier deletes don't shift
+		// the numbering of ones we haven't deleted yet.
+		for i := len(haveHashes) - 1; i >= common; i-- {
+			edits = append(edits, edit{op: "-D", line: fmt.Sprintf("-D %s %d", chain.Name, i+1)})
+		}
+
+		// Anything new beyond the common prefix gets appended in order.
+		for i := common; i < len(wantHashes); i++ {
+			edits = append(edits, edit{
+				op:   "-A",
+				line: chain.Rules[i].RenderAppend(chain.Name, "", t.Features),
+			})
+		}
+	}
+	return edits
+}
+
+// fullResync rebuilds every chain from scratch: flush then re-append every
+// rule.  Used as the fallback when an incremental diff fails to apply
+// cleanly (e.g. because our view of "actual" was stale).
+func (t *Transaction) fullResync(desired []*Chain) []edit {
+	var edits []edit
+	for _, chain := range desired {
+		edits = append(edits, edit{op: "-F", line: fmt.Sprintf(":%s -", chain.Name)})
+		for _, rule := range chain.Rules {
+			edits = append(edits, edit{op: "-A", line: rule.RenderAppend(chain.Name, "", t.Features)})
+		}
+	}
+	return edits
+}
+
+// restore renders edits as a single *<table> ... COMMIT block and feeds it to
+// iptables-restore --noflush in one shot.
+func (t *Transaction) restore(edits []edit, noFlush bool) error {
+	if len(edits) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%s\n", t.Table)
+	for _, e := range edits {
+		fmt.Fprintln(&buf, e.line)
+	}
+	fmt.Fprintln(&buf, "COMMIT")
+
+	cmd := t.restoreCmd(t.Table, noFlush)
+	cmd.Stdin = bytes.NewReader(buf.Bytes())
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("iptables-restore failed: %w; stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// ExtractHash pulls the stable rule hash out of a rendered rule's
+// "-m comment --comment" fragment, as stored by Chain.RuleHashes.  It returns
+// ok=false if the rule has no recognisable hash comment (e.g. a rule that
+// predates hash-based rendering).
+func ExtractHash(renderedRule string) (hash string, ok bool) {
+	m := commentHashRegexp.FindStringSubmatch(renderedRule)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
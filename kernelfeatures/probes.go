@@ -0,0 +1,171 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kernelfeatures probes the running kernel and iptables toolchain, once, for optional
+// capabilities that a renderer needs to know about before emitting an iptables/ip-rule/netlink
+// construct the kernel might silently ignore or reject outright. Modeled on the tailscale
+// linuxRouter's ipRuleAvailable/v6Available/fwmaskWorks probes: probe lazily on first use, cache
+// the result for the life of the process, and have every consumer read the cache instead of
+// re-probing per render.
+package kernelfeatures
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Features records the result of probing the kernel/toolchain this process is running on.
+type Features struct {
+	// IPv6Available is true if /proc/sys/net/ipv6 exists, i.e. the kernel's IPv6 stack is
+	// present (not compiled out or disabled at boot). The disable_ipv6 sysctl is not checked,
+	// so a stack disabled that way still reads as available.
+	IPv6Available bool
+
+	// IPSetMatchAvailable is true if iptables' "-m set" match extension is usable.
+	IPSetMatchAvailable bool
+
+	// FWMaskAvailable is true if "ip rule add fwmark x/y" (a mask alongside the mark) is
+	// accepted by the running kernel. Older kernels only accept a bare mark.
+	FWMaskAvailable bool
+
+	// ManageTempAddrAvailable is true if the kernel recognises the IFA_F_MANAGETEMPADDR address
+	// flag used by SLAAC privacy-extension (temporary) addresses.
+	ManageTempAddrAvailable bool
+
+	// GetNextHopAvailable is true if the kernel supports the RTM_GETNEXTHOP netlink request.
+	GetNextHopAvailable bool
+}
+
+var (
+	probeOnce   sync.Once
+	probeResult Features
+)
+
+// Get returns the cached probe results, running the probes on the first call and caching them
+// for the remaining lifetime of the process.
+func Get() Features {
+	probeOnce.Do(func() {
+		probeResult = probe()
+		log.WithFields(log.Fields{
+			"ipv6":           probeResult.IPv6Available,
+			"ipsetMatch":     probeResult.IPSetMatchAvailable,
+			"fwmask":         probeResult.FWMaskAvailable,
+			"manageTempAddr": probeResult.ManageTempAddrAvailable,
+			"getNextHop":     probeResult.GetNextHopAvailable,
+		}).Info("Probed kernel/iptables features.")
+		recordMetrics(probeResult)
+	})
+	return probeResult
+}
+
+func probe() Features {
+	major, minor, haveVersion := kernelVersion()
+	return Features{
+		IPv6Available:           ipv6Available(),
+		IPSetMatchAvailable:     iptablesSupportsMatch("set"),
+		FWMaskAvailable:         ipRuleSupportsFWMask(),
+		ManageTempAddrAvailable: haveVersion && kernelAtLeast(major, minor, 3, 14),
+		GetNextHopAvailable:     haveVersion && kernelAtLeast(major, minor, 5, 3),
+	}
+}
+
+func ipv6Available() bool {
+	_, err := os.Stat("/proc/sys/net/ipv6")
+	return err == nil
+}
+
+func iptablesSupportsMatch(match string) bool {
+	return exec.Command("iptables", "-m", match, "-h").Run() == nil
+}
+
+// probeRulePriority is a priority unlikely to collide with any rule Felix itself manages; it's
+// only ever used for the lifetime of a single RuleAdd/RuleDel pair below.
+const (
+	probeRulePriority = 32765
+	probeRuleTable    = 250
+)
+
+// ipRuleSupportsFWMask adds and immediately removes a throwaway "ip rule" with both a mark and a
+// mask, and reports whether the running kernel accepted it. A kernel that accepts the rule but
+// silently ignores the mask is not told apart from one that honours it.
+func ipRuleSupportsFWMask() bool {
+	rule := netlink.NewRule()
+	rule.Priority = probeRulePriority
+	rule.Mark = 1
+	rule.Mask = 1
+	rule.Table = probeRuleTable // never actually routed to -- the rule is removed immediately below.
+	if err := netlink.RuleAdd(rule); err != nil {
+		return false
+	}
+	if err := netlink.RuleDel(rule); err != nil {
+		log.WithError(err).Warn("Failed to remove fwmask probe rule; leaving it in place.")
+	}
+	return true
+}
+
+// kernelVersion returns the running kernel's major/minor version, e.g. (5, 4) for "5.4.0-generic".
+func kernelVersion() (major, minor int, ok bool) {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		log.WithError(err).Warn("Failed to read kernel version via uname.")
+		return 0, 0, false
+	}
+	release := unix.ByteSliceToString(uts.Release[:])
+	if n, _ := fmt.Sscanf(release, "%d.%d", &major, &minor); n < 2 {
+		log.WithField("release", release).Warn("Failed to parse kernel version.")
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+func kernelAtLeast(major, minor, wantMajor, wantMinor int) bool {
+	if major != wantMajor {
+		return major > wantMajor
+	}
+	return minor >= wantMinor
+}
+
+var (
+	gaugeFeatureAvailable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "felix_kernel_feature_available",
+		Help: "Whether an optional kernel/toolchain feature was detected as available (1) or not (0) at startup.",
+	}, []string{"feature"})
+)
+
+func init() {
+	prometheus.MustRegister(gaugeFeatureAvailable)
+}
+
+func recordMetrics(f Features) {
+	setGauge("ipv6", f.IPv6Available)
+	setGauge("ipset_match", f.IPSetMatchAvailable)
+	setGauge("fwmask", f.FWMaskAvailable)
+	setGauge("manage_temp_addr", f.ManageTempAddrAvailable)
+	setGauge("get_next_hop", f.GetNextHopAvailable)
+}
+
+func setGauge(feature string, available bool) {
+	value := 0.0
+	if available {
+		value = 1.0
+	}
+	gaugeFeatureAvailable.WithLabelValues(feature).Set(value)
+}
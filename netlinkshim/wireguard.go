@@ -0,0 +1,39 @@
+// Copyright (c) 2021 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package netlinkshim wraps the parts of wgctrl (and, by extension,
+// netlink) that the wireguard package needs, behind a small interface so
+// that package can be unit tested against a mock rather than a real
+// kernel WireGuard device.
+package netlinkshim
+
+import (
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Wireguard is the subset of wgctrl.Client's API the wireguard package
+// uses to manage a WireGuard device.
+type Wireguard interface {
+	Close() error
+	DeviceByName(name string) (*wgtypes.Device, error)
+	Devices() ([]*wgtypes.Device, error)
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+}
+
+// New opens a real wgctrl client talking to the kernel's WireGuard
+// implementation via netlink/genetlink.
+func New() (Wireguard, error) {
+	return wgctrl.New()
+}
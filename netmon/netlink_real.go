@@ -0,0 +1,62 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netmon
+
+import (
+	"github.com/vishvananda/netlink"
+)
+
+// netlinkReal is the netlinkStub backed by the real kernel, via vishvananda/netlink.
+type netlinkReal struct{}
+
+func (*netlinkReal) LinkList() ([]netlink.Link, error) {
+	return netlink.LinkList()
+}
+
+func (*netlinkReal) AddrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	return netlink.AddrList(link, family)
+}
+
+func (*netlinkReal) RouteList(link netlink.Link, family int) ([]netlink.Route, error) {
+	return netlink.RouteList(link, family)
+}
+
+func (*netlinkReal) NeighList(linkIndex, family int) ([]netlink.Neigh, error) {
+	return netlink.NeighList(linkIndex, family)
+}
+
+func (*netlinkReal) RuleList(family int) ([]netlink.Rule, error) {
+	return netlink.RuleList(family)
+}
+
+func (*netlinkReal) LinkSubscribeWithOptions(ch chan<- netlink.LinkUpdate, done <-chan struct{}, options netlink.LinkSubscribeOptions) error {
+	return netlink.LinkSubscribeWithOptions(ch, done, options)
+}
+
+func (*netlinkReal) AddrSubscribeWithOptions(ch chan<- netlink.AddrUpdate, done <-chan struct{}, options netlink.AddrSubscribeOptions) error {
+	return netlink.AddrSubscribeWithOptions(ch, done, options)
+}
+
+func (*netlinkReal) RouteSubscribeWithOptions(ch chan<- netlink.RouteUpdate, done <-chan struct{}, options netlink.RouteSubscribeOptions) error {
+	return netlink.RouteSubscribeWithOptions(ch, done, options)
+}
+
+func (*netlinkReal) NeighSubscribeWithOptions(ch chan<- netlink.NeighUpdate, done <-chan struct{}, options netlink.NeighSubscribeOptions) error {
+	return netlink.NeighSubscribeWithOptions(ch, done, options)
+}
+
+func (*netlinkReal) RuleSubscribeWithOptions(ch chan<- netlink.RuleUpdate, done <-chan struct{}, options netlink.RuleSubscribeOptions) error {
+	return netlink.RuleSubscribeWithOptions(ch, done, options)
+}
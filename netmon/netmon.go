@@ -0,0 +1,423 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package netmon owns the single RTNETLINK subscription that would otherwise be opened
+// separately by every consumer interested in link, address, route, neighbour or rule changes
+// (ifacemonitor.InterfaceMonitor, routetable.RouteTable, ...). A Monitor subscribes to the LINK,
+// ADDR, ROUTE, NEIGH and RULE groups once, fans each update out to that kind's subscribers, and
+// keeps a generation-numbered cache of links, addresses and routes (neighbours and rules are
+// not cached) so a consumer can read a consistent starting point
+// without doing its own List-and-diff resync. Modeled on tailscale's net/netmon.
+package netmon
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// reconnectCooldown is the minimum gap between the resyncs reconnect runs for a dropped
+// subscription. A session ends at the first subscription that drops, so five subscriptions
+// dying together cost one reconnect, not five. A drop within the cooldown skips reconnect's
+// resync and waits out the rest of the cooldown instead. Every new session still resyncs once
+// its subscriptions are open, whether or not reconnect did.
+const reconnectCooldown = 2 * time.Second
+
+type netlinkStub interface {
+	LinkList() ([]netlink.Link, error)
+	AddrList(link netlink.Link, family int) ([]netlink.Addr, error)
+	RouteList(link netlink.Link, family int) ([]netlink.Route, error)
+	NeighList(linkIndex, family int) ([]netlink.Neigh, error)
+	RuleList(family int) ([]netlink.Rule, error)
+
+	LinkSubscribeWithOptions(ch chan<- netlink.LinkUpdate, done <-chan struct{}, options netlink.LinkSubscribeOptions) error
+	AddrSubscribeWithOptions(ch chan<- netlink.AddrUpdate, done <-chan struct{}, options netlink.AddrSubscribeOptions) error
+	RouteSubscribeWithOptions(ch chan<- netlink.RouteUpdate, done <-chan struct{}, options netlink.RouteSubscribeOptions) error
+	NeighSubscribeWithOptions(ch chan<- netlink.NeighUpdate, done <-chan struct{}, options netlink.NeighSubscribeOptions) error
+	RuleSubscribeWithOptions(ch chan<- netlink.RuleUpdate, done <-chan struct{}, options netlink.RuleSubscribeOptions) error
+}
+
+// Monitor owns one netlink subscription and distributes its updates to whichever felix
+// components have called Subscribe*. Links, Addrs and Routes are safe to read from any
+// goroutine at any time, including before Start has completed its first resync (they just read
+// as empty until then).
+type Monitor struct {
+	netlinkStub netlinkStub
+
+	Links  *LinkTable
+	Addrs  *AddrTable
+	Routes *RouteTable
+
+	mu        sync.Mutex
+	linkSubs  map[chan netlink.LinkUpdate]struct{}
+	addrSubs  map[chan netlink.AddrUpdate]struct{}
+	routeSubs map[chan netlink.RouteUpdate]struct{}
+	neighSubs map[chan netlink.NeighUpdate]struct{}
+	ruleSubs  map[chan netlink.RuleUpdate]struct{}
+
+	lastReconnect time.Time
+}
+
+// New creates a Monitor that talks to the kernel over a real netlink socket.
+func New() *Monitor {
+	return newWithStub(&netlinkReal{})
+}
+
+func newWithStub(stub netlinkStub) *Monitor {
+	return &Monitor{
+		netlinkStub: stub,
+		Links:       newLinkTable(),
+		Addrs:       newAddrTable(),
+		Routes:      newRouteTable(),
+		linkSubs:    map[chan netlink.LinkUpdate]struct{}{},
+		addrSubs:    map[chan netlink.AddrUpdate]struct{}{},
+		routeSubs:   map[chan netlink.RouteUpdate]struct{}{},
+		neighSubs:   map[chan netlink.NeighUpdate]struct{}{},
+		ruleSubs:    map[chan netlink.RuleUpdate]struct{}{},
+	}
+}
+
+// SubscribeLinks registers ch to receive every LinkUpdate the monitor sees from the point of
+// the call onwards. Callers that also want the pre-existing state should read m.Links first,
+// then call SubscribeLinks -- Monitor makes no attempt to avoid a duplicate or missed update
+// across that gap because, unlike ifacemonitor.Watch, there's no per-subscriber snapshot here;
+// it's up to each typed monitor built on top of Monitor (e.g. ifacemonitor.InterfaceMonitor) to
+// resync its own higher-level view, the same way it would after any other racy update.
+func (m *Monitor) SubscribeLinks(ch chan netlink.LinkUpdate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.linkSubs[ch] = struct{}{}
+}
+
+func (m *Monitor) UnsubscribeLinks(ch chan netlink.LinkUpdate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.linkSubs, ch)
+}
+
+func (m *Monitor) SubscribeAddrs(ch chan netlink.AddrUpdate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.addrSubs[ch] = struct{}{}
+}
+
+func (m *Monitor) UnsubscribeAddrs(ch chan netlink.AddrUpdate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.addrSubs, ch)
+}
+
+func (m *Monitor) SubscribeRoutes(ch chan netlink.RouteUpdate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.routeSubs[ch] = struct{}{}
+}
+
+func (m *Monitor) UnsubscribeRoutes(ch chan netlink.RouteUpdate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.routeSubs, ch)
+}
+
+func (m *Monitor) SubscribeNeigh(ch chan netlink.NeighUpdate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.neighSubs[ch] = struct{}{}
+}
+
+func (m *Monitor) UnsubscribeNeigh(ch chan netlink.NeighUpdate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.neighSubs, ch)
+}
+
+func (m *Monitor) SubscribeRules(ch chan netlink.RuleUpdate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ruleSubs[ch] = struct{}{}
+}
+
+func (m *Monitor) UnsubscribeRules(ch chan netlink.RuleUpdate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.ruleSubs, ch)
+}
+
+// Start opens the shared subscription, does an initial full resync of Links/Addrs/Routes, and
+// runs the dispatch loop until stopCh is closed. It only returns once that loop has exited.
+func (m *Monitor) Start(stopCh <-chan struct{}) error {
+	for {
+		if err := m.runSession(stopCh); err != nil {
+			return err
+		}
+		select {
+		case <-stopCh:
+			return nil
+		default:
+		}
+		// The session ended because one of the underlying sockets was torn down (e.g. the
+		// kernel hit ENOBUFS and dropped us); runSession has already resynced. Loop round to
+		// reopen the subscriptions.
+	}
+}
+
+// runSession opens all five subscriptions, resyncs the caches, and dispatches updates until
+// stopCh closes or any one subscription's channel closes (which vishvananda/netlink does on an
+// unrecoverable read error, most commonly ENOBUFS from a slow reader).
+func (m *Monitor) runSession(stopCh <-chan struct{}) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	linkUpdates := make(chan netlink.LinkUpdate, 64)
+	addrUpdates := make(chan netlink.AddrUpdate, 64)
+	routeUpdates := make(chan netlink.RouteUpdate, 64)
+	neighUpdates := make(chan netlink.NeighUpdate, 64)
+	ruleUpdates := make(chan netlink.RuleUpdate, 64)
+
+	if err := m.netlinkStub.LinkSubscribeWithOptions(linkUpdates, done, netlink.LinkSubscribeOptions{}); err != nil {
+		return err
+	}
+	if err := m.netlinkStub.AddrSubscribeWithOptions(addrUpdates, done, netlink.AddrSubscribeOptions{}); err != nil {
+		return err
+	}
+	if err := m.netlinkStub.RouteSubscribeWithOptions(routeUpdates, done, netlink.RouteSubscribeOptions{}); err != nil {
+		return err
+	}
+	if err := m.netlinkStub.NeighSubscribeWithOptions(neighUpdates, done, netlink.NeighSubscribeOptions{}); err != nil {
+		return err
+	}
+	if err := m.netlinkStub.RuleSubscribeWithOptions(ruleUpdates, done, netlink.RuleSubscribeOptions{}); err != nil {
+		return err
+	}
+
+	if err := m.resync(); err != nil {
+		log.WithError(err).Warn("netmon: initial resync failed, will retry on next session")
+	}
+
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case upd, ok := <-linkUpdates:
+			if !ok {
+				return m.reconnect("link")
+			}
+			m.applyLinkUpdate(upd)
+			m.fanOutLink(upd)
+		case upd, ok := <-addrUpdates:
+			if !ok {
+				return m.reconnect("addr")
+			}
+			m.applyAddrUpdate(upd)
+			m.fanOutAddr(upd)
+		case upd, ok := <-routeUpdates:
+			if !ok {
+				return m.reconnect("route")
+			}
+			m.applyRouteUpdate(upd)
+			m.fanOutRoute(upd)
+		case upd, ok := <-neighUpdates:
+			if !ok {
+				return m.reconnect("neigh")
+			}
+			m.fanOutNeigh(upd)
+		case upd, ok := <-ruleUpdates:
+			if !ok {
+				return m.reconnect("rule")
+			}
+			m.fanOutRule(upd)
+		}
+	}
+}
+
+// reconnect logs why the session ended and resyncs the caches -- once, no matter how many of
+// the five subscriptions died together -- before Start reopens them. If the previous resync
+// here was less than reconnectCooldown ago, it skips the resync and sleeps out the cooldown.
+func (m *Monitor) reconnect(kind string) error {
+	m.mu.Lock()
+	sinceLast := time.Since(m.lastReconnect)
+	if sinceLast < reconnectCooldown {
+		m.mu.Unlock()
+		log.WithField("kind", kind).Debug("netmon: subscription dropped during reconnect cooldown, skipping extra resync")
+		time.Sleep(reconnectCooldown - sinceLast)
+		return nil
+	}
+	m.lastReconnect = time.Now()
+	m.mu.Unlock()
+
+	log.WithField("kind", kind).Warn("netmon: netlink subscription dropped, resyncing")
+	return m.resync()
+}
+
+func (m *Monitor) applyLinkUpdate(upd netlink.LinkUpdate) {
+	if upd.Header.Type == 0 {
+		return
+	}
+	const rtmDelLink = 17 // syscall.RTM_DELLINK, spelled out to avoid a build-tag-only import
+	if upd.Header.Type == rtmDelLink {
+		m.Links.delete(int(upd.Index))
+		return
+	}
+	m.Links.set(int(upd.Index), upd.Link)
+}
+
+func (m *Monitor) applyAddrUpdate(upd netlink.AddrUpdate) {
+	existing := m.Addrs.Get(upd.LinkIndex)
+	if upd.NewAddr {
+		m.Addrs.set(upd.LinkIndex, append(existing, netlink.Addr{IPNet: &upd.LinkAddress}))
+		return
+	}
+	filtered := existing[:0]
+	for _, addr := range existing {
+		if addr.IPNet.String() != upd.LinkAddress.String() {
+			filtered = append(filtered, addr)
+		}
+	}
+	m.Addrs.set(upd.LinkIndex, filtered)
+}
+
+func (m *Monitor) applyRouteUpdate(upd netlink.RouteUpdate) {
+	existing := m.Routes.Get(upd.Route.LinkIndex)
+	const rtmDelRoute = 25 // syscall.RTM_DELROUTE
+	if upd.Type == rtmDelRoute {
+		filtered := existing[:0]
+		for _, route := range existing {
+			if route.Dst.String() != upd.Route.Dst.String() {
+				filtered = append(filtered, route)
+			}
+		}
+		m.Routes.set(upd.Route.LinkIndex, filtered)
+		return
+	}
+	m.Routes.set(upd.Route.LinkIndex, append(existing, upd.Route))
+}
+
+// fanOutLink, and its four siblings below, snapshot the subscriber set under m.mu and then send
+// outside the lock, so Subscribe*/Unsubscribe*/reconnect callers, which contend on the same
+// mutex, don't wait behind a slow subscriber. The sends are blocking and made from the single
+// dispatch loop, so a slow subscriber (e.g. ifacemonitor's 64-deep buffer filling during a
+// netlink storm) still holds up dispatch of every update kind until it reads.
+func (m *Monitor) fanOutLink(upd netlink.LinkUpdate) {
+	m.mu.Lock()
+	subs := make([]chan netlink.LinkUpdate, 0, len(m.linkSubs))
+	for ch := range m.linkSubs {
+		subs = append(subs, ch)
+	}
+	m.mu.Unlock()
+	for _, ch := range subs {
+		ch <- upd
+	}
+}
+
+func (m *Monitor) fanOutAddr(upd netlink.AddrUpdate) {
+	m.mu.Lock()
+	subs := make([]chan netlink.AddrUpdate, 0, len(m.addrSubs))
+	for ch := range m.addrSubs {
+		subs = append(subs, ch)
+	}
+	m.mu.Unlock()
+	for _, ch := range subs {
+		ch <- upd
+	}
+}
+
+func (m *Monitor) fanOutRoute(upd netlink.RouteUpdate) {
+	m.mu.Lock()
+	subs := make([]chan netlink.RouteUpdate, 0, len(m.routeSubs))
+	for ch := range m.routeSubs {
+		subs = append(subs, ch)
+	}
+	m.mu.Unlock()
+	for _, ch := range subs {
+		ch <- upd
+	}
+}
+
+func (m *Monitor) fanOutNeigh(upd netlink.NeighUpdate) {
+	m.mu.Lock()
+	subs := make([]chan netlink.NeighUpdate, 0, len(m.neighSubs))
+	for ch := range m.neighSubs {
+		subs = append(subs, ch)
+	}
+	m.mu.Unlock()
+	for _, ch := range subs {
+		ch <- upd
+	}
+}
+
+func (m *Monitor) fanOutRule(upd netlink.RuleUpdate) {
+	m.mu.Lock()
+	subs := make([]chan netlink.RuleUpdate, 0, len(m.ruleSubs))
+	for ch := range m.ruleSubs {
+		subs = append(subs, ch)
+	}
+	m.mu.Unlock()
+	for _, ch := range subs {
+		ch <- upd
+	}
+}
+
+// resync rebuilds Links, Addrs and Routes from scratch via List calls. It's used both for
+// Start's initial snapshot and to recover from a dropped subscription, so it must never assume
+// the caches start empty.
+func (m *Monitor) resync() error {
+	links, err := m.netlinkStub.LinkList()
+	if err != nil {
+		return err
+	}
+	m.Links.replaceAll(links)
+
+	live := make(map[int]bool, len(links))
+	for _, link := range links {
+		ifIndex := link.Attrs().Index
+		live[ifIndex] = true
+		var addrs []netlink.Addr
+		for _, family := range [2]int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+			famAddrs, err := m.netlinkStub.AddrList(link, family)
+			if err != nil {
+				log.WithError(err).WithField("ifIndex", ifIndex).Warn("netmon: addr list failed during resync")
+				continue
+			}
+			addrs = append(addrs, famAddrs...)
+		}
+		m.Addrs.set(ifIndex, addrs)
+
+		var routes []netlink.Route
+		for _, family := range [2]int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+			famRoutes, err := m.netlinkStub.RouteList(link, family)
+			if err != nil {
+				log.WithError(err).WithField("ifIndex", ifIndex).Warn("netmon: route list failed during resync")
+				continue
+			}
+			routes = append(routes, famRoutes...)
+		}
+		m.Routes.set(ifIndex, routes)
+	}
+
+	// Drop cached addresses and routes of links that no longer exist. Only this goroutine
+	// writes the tables, so ranging over them here without their locks is safe.
+	for ifIndex := range m.Addrs.byIndex {
+		if !live[ifIndex] {
+			m.Addrs.delete(ifIndex)
+		}
+	}
+	for ifIndex := range m.Routes.byIndex {
+		if !live[ifIndex] {
+			m.Routes.delete(ifIndex)
+		}
+	}
+	return nil
+}
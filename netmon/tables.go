@@ -0,0 +1,166 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netmon
+
+import (
+	"sync"
+
+	"github.com/vishvananda/netlink"
+)
+
+// LinkTable is Monitor's cache of the kernel's current links, keyed by ifindex. Generation is
+// bumped every time the cache is mutated, so a consumer that only cares whether anything has
+// changed since it last looked can poll Generation instead of diffing List itself.
+type LinkTable struct {
+	mu         sync.Mutex
+	generation uint64
+	byIndex    map[int]netlink.Link
+}
+
+func newLinkTable() *LinkTable {
+	return &LinkTable{byIndex: map[int]netlink.Link{}}
+}
+
+func (t *LinkTable) Generation() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.generation
+}
+
+func (t *LinkTable) Get(ifIndex int) (netlink.Link, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	link, ok := t.byIndex[ifIndex]
+	return link, ok
+}
+
+func (t *LinkTable) List() []netlink.Link {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	links := make([]netlink.Link, 0, len(t.byIndex))
+	for _, link := range t.byIndex {
+		links = append(links, link)
+	}
+	return links
+}
+
+func (t *LinkTable) set(ifIndex int, link netlink.Link) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.byIndex[ifIndex] = link
+	t.generation++
+}
+
+func (t *LinkTable) delete(ifIndex int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.byIndex[ifIndex]; !ok {
+		return
+	}
+	delete(t.byIndex, ifIndex)
+	t.generation++
+}
+
+func (t *LinkTable) replaceAll(links []netlink.Link) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.byIndex = make(map[int]netlink.Link, len(links))
+	for _, link := range links {
+		t.byIndex[link.Attrs().Index] = link
+	}
+	t.generation++
+}
+
+// AddrTable is Monitor's cache of the kernel's current addresses, keyed by ifindex.
+type AddrTable struct {
+	mu         sync.Mutex
+	generation uint64
+	byIndex    map[int][]netlink.Addr
+}
+
+func newAddrTable() *AddrTable {
+	return &AddrTable{byIndex: map[int][]netlink.Addr{}}
+}
+
+func (t *AddrTable) Generation() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.generation
+}
+
+func (t *AddrTable) Get(ifIndex int) []netlink.Addr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]netlink.Addr(nil), t.byIndex[ifIndex]...)
+}
+
+func (t *AddrTable) set(ifIndex int, addrs []netlink.Addr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.byIndex[ifIndex] = addrs
+	t.generation++
+}
+
+func (t *AddrTable) delete(ifIndex int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.byIndex[ifIndex]; !ok {
+		return
+	}
+	delete(t.byIndex, ifIndex)
+	t.generation++
+}
+
+// RouteTable is Monitor's cache of the kernel's current routes, keyed by the owning ifindex. (It
+// is unrelated to, and exists to eventually be consumed by, routetable.RouteTable -- the
+// component that programs Calico's own routes.)
+type RouteTable struct {
+	mu         sync.Mutex
+	generation uint64
+	byIndex    map[int][]netlink.Route
+}
+
+func newRouteTable() *RouteTable {
+	return &RouteTable{byIndex: map[int][]netlink.Route{}}
+}
+
+func (t *RouteTable) Generation() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.generation
+}
+
+func (t *RouteTable) Get(ifIndex int) []netlink.Route {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]netlink.Route(nil), t.byIndex[ifIndex]...)
+}
+
+func (t *RouteTable) set(ifIndex int, routes []netlink.Route) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.byIndex[ifIndex] = routes
+	t.generation++
+}
+
+func (t *RouteTable) delete(ifIndex int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.byIndex[ifIndex]; !ok {
+		return
+	}
+	delete(t.byIndex, ifIndex)
+	t.generation++
+}
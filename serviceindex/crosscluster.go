@@ -0,0 +1,196 @@
+// Copyright (c) 2021 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serviceindex
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	discovery "k8s.io/api/discovery/v1"
+
+	"github.com/projectcalico/felix/labelindex"
+	"github.com/projectcalico/libcalico-go/lib/backend/model"
+)
+
+// clusterServiceKey uniquely identifies a service in a particular remote
+// cluster (or the local one, for which cluster == localClusterName).  We
+// namespace the key by cluster so that identically-named services in
+// different clusters never collide.
+func clusterServiceKey(cluster, svc string) string {
+	if cluster == localClusterName {
+		return svc
+	}
+	return fmt.Sprintf("%s/%s", cluster, svc)
+}
+
+// PeerService declares that endpoints contributed by remoteSvc (a service
+// named "<namespace>/<name>" in the named remote cluster) should also count
+// as members of localSvc's (local) IP set.  This is how Felix implements
+// cross-cluster service peering: rather than merging datastores, each
+// cluster's EndpointSlices keep flowing into this ServiceIndex tagged with
+// their origin cluster, and PeerService wires the two together.
+func (idx *ServiceIndex) PeerService(localSvc, remoteCluster, remoteSvc string) {
+	if remoteCluster == localClusterName {
+		log.WithField("service", remoteSvc).Warn("Ignoring peer request for local cluster")
+		return
+	}
+	localKey := clusterServiceKey(localClusterName, localSvc)
+	remoteKey := clusterServiceKey(remoteCluster, remoteSvc)
+
+	if idx.peerServices[localKey][remoteKey] {
+		return // Already peered.
+	}
+	if idx.peerServices[localKey] == nil {
+		idx.peerServices[localKey] = map[string]bool{}
+	}
+	idx.peerServices[localKey][remoteKey] = true
+	if idx.peeredBy[remoteKey] == nil {
+		idx.peeredBy[remoteKey] = map[string]bool{}
+	}
+	idx.peeredBy[remoteKey][localKey] = true
+
+	log.WithFields(log.Fields{
+		"local":  localKey,
+		"remote": remoteKey,
+	}).Info("Peering cross-cluster service")
+
+	// If the local service is already active and the remote endpoints are
+	// already known, contribute them immediately.
+	ipSet, ok := idx.activeIPSetsByService[localKey]
+	if !ok {
+		return
+	}
+	for _, eps := range idx.endpointSlicesByService[remoteKey] {
+		idx.contributeMembers(ipSet, idx.membersFromEndpointSlice(eps))
+	}
+}
+
+// UnpeerService undoes a prior PeerService call, withdrawing any IP set
+// members that were only present because of the peering.
+func (idx *ServiceIndex) UnpeerService(localSvc, remoteCluster, remoteSvc string) {
+	localKey := clusterServiceKey(localClusterName, localSvc)
+	remoteKey := clusterServiceKey(remoteCluster, remoteSvc)
+
+	if !idx.peerServices[localKey][remoteKey] {
+		return
+	}
+	delete(idx.peerServices[localKey], remoteKey)
+	if len(idx.peerServices[localKey]) == 0 {
+		delete(idx.peerServices, localKey)
+	}
+	delete(idx.peeredBy[remoteKey], localKey)
+	if len(idx.peeredBy[remoteKey]) == 0 {
+		delete(idx.peeredBy, remoteKey)
+	}
+
+	ipSet, ok := idx.activeIPSetsByService[localKey]
+	if !ok {
+		return
+	}
+	for _, eps := range idx.endpointSlicesByService[remoteKey] {
+		idx.withdrawMembers(ipSet, idx.membersFromEndpointSlice(eps))
+	}
+}
+
+// UpdateEndpointSliceForCluster is the cross-cluster-aware counterpart of
+// UpdateEndpointSlice: it records an EndpointSlice as belonging to the named
+// remote cluster (use localClusterName, i.e. "", for our own cluster, which
+// is equivalent to calling UpdateEndpointSlice) and, via any registered
+// PeerService links, fans its membership out to every local service it
+// has been peered with.
+func (idx *ServiceIndex) UpdateEndpointSliceForCluster(cluster string, es *discovery.EndpointSlice) {
+	if cluster == localClusterName {
+		idx.UpdateEndpointSlice(es)
+		return
+	}
+
+	svc := clusterServiceKey(cluster, serviceName(es))
+	if _, ok := idx.endpointSlicesByService[svc]; !ok {
+		idx.endpointSlicesByService[svc] = map[string]*discovery.EndpointSlice{}
+	}
+	k := fmt.Sprintf("%s/%s/%s", cluster, es.Namespace, es.Name)
+	cached := idx.endpointSlices[k]
+	oldContribution := idx.membersFromEndpointSlice(cached)
+	newContribution := idx.membersFromEndpointSlice(es)
+
+	for localKey := range idx.peeredBy[svc] {
+		if ipSet, ok := idx.activeIPSetsByService[localKey]; ok {
+			idx.contributeMembers(ipSet, newContribution)
+			idx.withdrawMembers(ipSet, oldContribution)
+		}
+	}
+
+	idx.endpointSlicesByService[svc][k] = es
+	idx.endpointSlices[k] = es
+}
+
+// DeleteEndpointSliceForCluster is the cross-cluster-aware counterpart of
+// DeleteEndpointSlice.
+func (idx *ServiceIndex) DeleteEndpointSliceForCluster(cluster, namespace, name string) {
+	if cluster == localClusterName {
+		idx.DeleteEndpointSlice(model.ResourceKey{
+			Kind:      model.KindKubernetesEndpointSlice,
+			Namespace: namespace,
+			Name:      name,
+		})
+		return
+	}
+
+	k := fmt.Sprintf("%s/%s/%s", cluster, namespace, name)
+	es, ok := idx.endpointSlices[k]
+	if !ok {
+		return
+	}
+	svc := clusterServiceKey(cluster, serviceName(es))
+	oldContribution := idx.membersFromEndpointSlice(es)
+	for localKey := range idx.peeredBy[svc] {
+		if ipSet, ok := idx.activeIPSetsByService[localKey]; ok {
+			idx.withdrawMembers(ipSet, oldContribution)
+		}
+	}
+
+	delete(idx.endpointSlicesByService[svc], k)
+	if len(idx.endpointSlicesByService[svc]) == 0 {
+		delete(idx.endpointSlicesByService, svc)
+	}
+	delete(idx.endpointSlices, k)
+}
+
+// contributeMembers reference-counts each member in, emitting OnMemberAdded
+// the first time a member goes from 0 to 1 references.
+func (idx *ServiceIndex) contributeMembers(ipSet *ipSetData, members []labelindex.IPSetMember) {
+	for _, member := range members {
+		refCount := ipSet.memberToRefCount[member] + 1
+		if refCount == 1 {
+			idx.OnMemberAdded(ipSet.ID, member)
+		}
+		ipSet.memberToRefCount[member] = refCount
+	}
+}
+
+// withdrawMembers reference-counts each member out, emitting OnMemberRemoved
+// when a member's count hits 0.
+func (idx *ServiceIndex) withdrawMembers(ipSet *ipSetData, members []labelindex.IPSetMember) {
+	for _, member := range members {
+		newRefCount := ipSet.memberToRefCount[member] - 1
+		if newRefCount == 0 {
+			idx.OnMemberRemoved(ipSet.ID, member)
+			delete(ipSet.memberToRefCount, member)
+		} else {
+			ipSet.memberToRefCount[member] = newRefCount
+		}
+	}
+}
@@ -19,6 +19,7 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	v1 "k8s.io/api/core/v1"
 	discovery "k8s.io/api/discovery/v1"
 
 	"github.com/projectcalico/felix/dispatcher"
@@ -30,6 +31,11 @@ import (
 
 type ServiceMatchCallback func(ipSetID string, member labelindex.IPSetMember)
 
+// localClusterName is the pseudo cluster name used for endpoint slices from
+// our own cluster's datastore, so that local and cross-cluster-peered
+// services share the same key scheme.
+const localClusterName = ""
+
 type ServiceIndex struct {
 	// cache of all endpoint slices, indexed by service name and slice namespace/name.
 	endpointSlices          map[string]*discovery.EndpointSlice
@@ -39,6 +45,19 @@ type ServiceIndex struct {
 	activeIPSetsByID      map[string]*ipSetData
 	activeIPSetsByService map[string]*ipSetData
 
+	// peerServices maps a local service key to the remote cluster services
+	// that are peered with it: endpoint slices seen for any of those remote
+	// services also contribute to the local service's IP set.  See
+	// PeerService/UnpeerService.
+	peerServices map[string]map[string]bool
+	// peeredBy is the reverse of peerServices: remote service key -> set of
+	// local service keys it contributes to.  Kept in sync with peerServices.
+	peeredBy map[string]map[string]bool
+
+	// localZone is the topology zone this node is in, used to filter
+	// endpoints by topology-aware hints; see SetLocalZone.
+	localZone string
+
 	// Callback functions
 	OnMemberAdded   ServiceMatchCallback
 	OnMemberRemoved ServiceMatchCallback
@@ -46,6 +65,12 @@ type ServiceIndex struct {
 
 func NewServiceIndex() *ServiceIndex {
 	idx := ServiceIndex{
+		endpointSlices:          map[string]*discovery.EndpointSlice{},
+		endpointSlicesByService: map[string]map[string]*discovery.EndpointSlice{},
+		activeIPSetsByID:        map[string]*ipSetData{},
+		activeIPSetsByService:   map[string]*ipSetData{},
+		peerServices:            map[string]map[string]bool{},
+		peeredBy:                map[string]map[string]bool{},
 		// Callback functions
 		OnMemberAdded:   func(ipSetID string, member labelindex.IPSetMember) {},
 		OnMemberRemoved: func(ipSetID string, member labelindex.IPSetMember) {},
@@ -170,11 +195,15 @@ func (idx *ServiceIndex) membersFromEndpointSlice(es *discovery.EndpointSlice) [
 	// it means ALL ports.
 	members := []labelindex.IPSetMember{}
 	for _, ep := range es.Endpoints {
+		if !idx.endpointMatchesTopology(ep) {
+			continue
+		}
 		for _, port := range es.Ports {
 			// If the port number is nil, ports are not restricted and left
 			// to be interpreted by the context of the consumer. In our case, we will consider
 			// a lack of port to mean no IP set membership.
 			if port.Port != nil {
+				proto := protocolFromK8s(port.Protocol)
 				for _, addr := range ep.Addresses {
 					cidr, err := ip.ParseCIDROrIP(addr)
 					if err != nil {
@@ -183,7 +212,7 @@ func (idx *ServiceIndex) membersFromEndpointSlice(es *discovery.EndpointSlice) [
 					}
 					members = append(members, labelindex.IPSetMember{
 						CIDR:       cidr,
-						Protocol:   labelindex.ProtocolTCP, // TODO: Fill in with proper protocol.
+						Protocol:   proto,
 						PortNumber: uint16(*port.Port),
 					})
 				}
@@ -193,6 +222,100 @@ func (idx *ServiceIndex) membersFromEndpointSlice(es *discovery.EndpointSlice) [
 	return members
 }
 
+// protocolFromK8s maps an EndpointSlice port's protocol onto our internal
+// IPSetMember protocol enum, defaulting to TCP to match the Kubernetes API
+// default for ServicePort/EndpointPort.
+func protocolFromK8s(p *v1.Protocol) labelindex.IPSetPortProtocol {
+	if p == nil {
+		return labelindex.ProtocolTCP
+	}
+	switch *p {
+	case v1.ProtocolUDP:
+		return labelindex.ProtocolUDP
+	case v1.ProtocolSCTP:
+		return labelindex.ProtocolSCTP
+	default:
+		return labelindex.ProtocolTCP
+	}
+}
+
+// endpointMatchesTopology reports whether ep should be included given the
+// index's configured topology preference.  With no local zone configured
+// (the default), every endpoint matches, same as before topology-aware
+// routing existed. Once SetLocalZone has been called, endpoints carrying
+// topology hints are filtered down to those hinted for our zone; endpoints
+// with no hints at all (e.g. older kube-proxy/EndpointSlice controllers)
+// still match everything, since "no hint" means "no restriction" rather
+// than "not for us".
+func (idx *ServiceIndex) endpointMatchesTopology(ep discovery.Endpoint) bool {
+	if idx.localZone == "" {
+		return true
+	}
+	if ep.Hints == nil || len(ep.Hints.ForZones) == 0 {
+		return true
+	}
+	for _, z := range ep.Hints.ForZones {
+		if z.Name == idx.localZone {
+			return true
+		}
+	}
+	return false
+}
+
+// SetLocalZone configures topology-aware filtering: once set, only
+// endpoints hinted (via EndpointSlice topology-aware hints) for this zone
+// will contribute IP set members.  Pass "" to disable filtering again.
+func (idx *ServiceIndex) SetLocalZone(zone string) {
+	if idx.localZone == zone {
+		return
+	}
+	log.WithFields(log.Fields{"old": idx.localZone, "new": zone}).Info("Local topology zone changed, recomputing IP set memberships")
+	idx.localZone = zone
+	idx.recomputeAllMemberships()
+}
+
+// recomputeAllMemberships re-derives every active IP set's membership from
+// the current endpoint slice cache, emitting add/remove callbacks for
+// whatever changed.  Used when a change to our topology configuration
+// could affect which endpoints are in-scope for every service at once.
+//
+// A service's desired membership is the union of its own (local) endpoint
+// slices and whatever its peered remote services (see PeerService) are
+// contributing, and the recount must preserve true reference counts rather
+// than flattening every member to 1: a member can legitimately be
+// contributed by more than one slice or peer, and a later single
+// withdrawMembers call must not drop it while another contributor still
+// references it.
+func (idx *ServiceIndex) recomputeAllMemberships() {
+	for svc, ipSet := range idx.activeIPSetsByService {
+		desired := map[labelindex.IPSetMember]uint64{}
+		addContributions := func(slices map[string]*discovery.EndpointSlice) {
+			for _, eps := range slices {
+				for _, m := range idx.membersFromEndpointSlice(eps) {
+					desired[m]++
+				}
+			}
+		}
+		addContributions(idx.endpointSlicesByService[svc])
+		for remoteKey := range idx.peerServices[svc] {
+			addContributions(idx.endpointSlicesByService[remoteKey])
+		}
+
+		for member, refCount := range desired {
+			if ipSet.memberToRefCount[member] == 0 {
+				idx.OnMemberAdded(ipSet.ID, member)
+			}
+			ipSet.memberToRefCount[member] = refCount
+		}
+		for member := range ipSet.memberToRefCount {
+			if _, ok := desired[member]; !ok {
+				idx.OnMemberRemoved(ipSet.ID, member)
+				delete(ipSet.memberToRefCount, member)
+			}
+		}
+	}
+}
+
 func (idx *ServiceIndex) UpdateIPSet(id string, serviceName string) {
 	if curr, ok := idx.activeIPSetsByID[id]; !ok {
 		// No existing entry - this is a new IP set.
@@ -218,14 +341,16 @@ func (idx *ServiceIndex) UpdateIPSet(id string, serviceName string) {
 	// We need to scan for possible updates to the IP set membership. Check endpoint slices for this
 	// service to determine endpoints to contribute.
 	for _, eps := range idx.endpointSlicesByService[serviceName] {
-		members := idx.membersFromEndpointSlice(eps)
-		for _, m := range members {
-			refCount := as.memberToRefCount[m]
-			if refCount == 0 {
-				// This member hasn't been sent to the data plane yet. Send it.
-				idx.OnMemberAdded(id, m)
-			}
-			as.memberToRefCount[m] = refCount + 1
+		idx.contributeMembers(as, idx.membersFromEndpointSlice(eps))
+	}
+
+	// Also pick up any peered remote services' endpoints, in case PeerService was called (and the
+	// remote EndpointSlices were already cached) before this IP set was activated -- otherwise
+	// those peer members would stay missing until the next remote EndpointSlice update, the same
+	// union recomputeAllMemberships and PeerService's already-active branch already apply.
+	for remoteKey := range idx.peerServices[serviceName] {
+		for _, eps := range idx.endpointSlicesByService[remoteKey] {
+			idx.contributeMembers(as, idx.membersFromEndpointSlice(eps))
 		}
 	}
 }
@@ -9,4 +9,10 @@ type Config struct {
 	RoutingTableIndex   int
 	InterfaceName       string
 	MTU                 int
+
+	// Backend names a WireGuard implementation: BackendKernel or
+	// BackendUserspace. Its zero value is the empty string, and no code
+	// in this package reads it; a userspace device is created directly
+	// with NewUserspaceDevice.
+	Backend BackendKind
 }
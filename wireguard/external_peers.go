@@ -0,0 +1,173 @@
+// Copyright (c) 2021 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wireguard
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/projectcalico/felix/ip"
+)
+
+// ExternalPeer is a WireGuard peer learned from a PeerCRD rather than
+// derived from a Calico Node resource -- e.g. an on-prem gateway or a
+// third-party service that isn't part of the cluster but still needs a
+// secure tunnel to it.
+type ExternalPeer struct {
+	Name                string
+	PublicKey           wgtypes.Key
+	Endpoint            string // host:port, resolved lazily at configure time.
+	AllowedCIDRs        []ip.CIDR
+	PersistentKeepalive time.Duration
+}
+
+// externalPeerSource is implemented by whatever watches PeerCRDs; kept as an
+// interface so the merging logic here doesn't need to know about the
+// datastore/client-go machinery behind it.
+type externalPeerSource interface {
+	ExternalPeers() map[string]ExternalPeer // keyed by CRD name
+}
+
+// PeerStore tracks the peers that should be configured on the local
+// WireGuard device, merging two independent sources: peers derived from
+// other Calico Nodes (the traditional mesh) and peers sourced from
+// PeerCRDs. Each source owns its own namespace of keys so that an external
+// peer can never accidentally shadow a node-derived one or vice versa.
+type PeerStore struct {
+	nodePeersByPubKey     map[wgtypes.Key]Peer
+	externalPeersByPubKey map[wgtypes.Key]ExternalPeer
+
+	// configCache holds the already-built WireguardPeerConfig for each
+	// peer we've computed one for since it last changed.  In a large
+	// cluster, most peers don't change on any given update, so rebuilding
+	// (and re-allocating the AllowedCIDRs slice for) every peer on every
+	// AllPeers() call is wasted CPU and a steady source of GC pressure;
+	// we only rebuild a peer's entry when Update*Peer/Remove*Peer tells
+	// us it's stale.
+	configCache map[wgtypes.Key]WireguardPeerConfig
+}
+
+// Peer is a node-derived WireGuard peer, i.e. one discovered from another
+// Calico Node's WireGuard public key and IP pool allocations.
+type Peer struct {
+	NodeName     string
+	PublicKey    wgtypes.Key
+	Endpoint     string
+	AllowedCIDRs []ip.CIDR
+}
+
+func NewPeerStore() *PeerStore {
+	return &PeerStore{
+		nodePeersByPubKey:     map[wgtypes.Key]Peer{},
+		externalPeersByPubKey: map[wgtypes.Key]ExternalPeer{},
+		configCache:           map[wgtypes.Key]WireguardPeerConfig{},
+	}
+}
+
+// UpdateNodePeer adds or updates a node-derived peer.
+func (s *PeerStore) UpdateNodePeer(p Peer) {
+	if existing, ok := s.externalPeersByPubKey[p.PublicKey]; ok {
+		log.WithFields(log.Fields{
+			"node":         p.NodeName,
+			"externalPeer": existing.Name,
+			"publicKey":    p.PublicKey,
+		}).Warn("Node-derived peer shares a public key with a PeerCRD-sourced peer; node wins")
+		delete(s.externalPeersByPubKey, p.PublicKey)
+	}
+	s.nodePeersByPubKey[p.PublicKey] = p
+	delete(s.configCache, p.PublicKey)
+}
+
+// RemoveNodePeer removes a previously added node-derived peer.
+func (s *PeerStore) RemoveNodePeer(publicKey wgtypes.Key) {
+	delete(s.nodePeersByPubKey, publicKey)
+	delete(s.configCache, publicKey)
+}
+
+// UpdateExternalPeer adds or updates a PeerCRD-sourced peer.  It's ignored
+// (with a warning) if a node already owns that public key -- node-derived
+// peers always take precedence since they represent cluster members.
+func (s *PeerStore) UpdateExternalPeer(p ExternalPeer) {
+	if _, ok := s.nodePeersByPubKey[p.PublicKey]; ok {
+		log.WithFields(log.Fields{
+			"externalPeer": p.Name,
+			"publicKey":    p.PublicKey,
+		}).Warn("Ignoring PeerCRD peer: public key already owned by a Calico Node")
+		return
+	}
+	s.externalPeersByPubKey[p.PublicKey] = p
+	delete(s.configCache, p.PublicKey)
+}
+
+// RemoveExternalPeer removes a previously added PeerCRD-sourced peer.
+func (s *PeerStore) RemoveExternalPeer(publicKey wgtypes.Key) {
+	delete(s.externalPeersByPubKey, publicKey)
+	delete(s.configCache, publicKey)
+}
+
+// WireguardPeerConfig is the merged view of all known peers, in the shape
+// needed to build a wgtypes.Config for ConfigureDevice.
+type WireguardPeerConfig struct {
+	PublicKey           wgtypes.Key
+	Endpoint            string
+	AllowedCIDRs        []ip.CIDR
+	PersistentKeepalive time.Duration
+}
+
+// AllPeers returns every known peer -- node-derived and PeerCRD-sourced --
+// merged into one list ready for the dataplane to configure.  Node-derived
+// peers are listed first so that, for diagnostics, the cluster mesh is
+// easy to tell apart from the external peers appended after it. Each
+// peer's WireguardPeerConfig is only rebuilt if it isn't already cached
+// from a previous call, so a resync of an unchanged large mesh is mostly
+// cache hits rather than fresh allocations.
+func (s *PeerStore) AllPeers() []WireguardPeerConfig {
+	merged := make([]WireguardPeerConfig, 0, len(s.nodePeersByPubKey)+len(s.externalPeersByPubKey))
+	for pubKey := range s.nodePeersByPubKey {
+		merged = append(merged, s.configFor(pubKey))
+	}
+	for pubKey := range s.externalPeersByPubKey {
+		merged = append(merged, s.configFor(pubKey))
+	}
+	return merged
+}
+
+// configFor returns the cached WireguardPeerConfig for pubKey, building
+// and caching it first if this is the first request since the peer last
+// changed.
+func (s *PeerStore) configFor(pubKey wgtypes.Key) WireguardPeerConfig {
+	if cfg, ok := s.configCache[pubKey]; ok {
+		return cfg
+	}
+	var cfg WireguardPeerConfig
+	if p, ok := s.nodePeersByPubKey[pubKey]; ok {
+		cfg = WireguardPeerConfig{
+			PublicKey:    p.PublicKey,
+			Endpoint:     p.Endpoint,
+			AllowedCIDRs: p.AllowedCIDRs,
+		}
+	} else if p, ok := s.externalPeersByPubKey[pubKey]; ok {
+		cfg = WireguardPeerConfig{
+			PublicKey:           p.PublicKey,
+			Endpoint:            p.Endpoint,
+			AllowedCIDRs:        p.AllowedCIDRs,
+			PersistentKeepalive: p.PersistentKeepalive,
+		}
+	}
+	s.configCache[pubKey] = cfg
+	return cfg
+}
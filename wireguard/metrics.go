@@ -0,0 +1,294 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wireguard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/projectcalico/felix/netlinkshim"
+)
+
+const (
+	// staleHandshakeMultiplier sets how many times a peer's keepalive interval its handshake is
+	// allowed to be old before wireguard_peer_connected reports it as disconnected. WireGuard
+	// rekeys well within one keepalive interval in normal operation, so several missed keepalives
+	// is a real problem, not just scheduling jitter.
+	staleHandshakeMultiplier = 3
+
+	// defaultKeepaliveInterval is the staleness window used for a peer with no configured
+	// PersistentKeepaliveInterval, matching WireGuard's own default rekey-after-time.
+	defaultKeepaliveInterval = 2 * time.Minute
+)
+
+// Metrics is a prometheus.Collector that reports per-peer WireGuard byte
+// counters and handshake times, plus device-level metadata, read straight
+// from the kernel's WireGuard device rather than from anything Felix
+// itself tracks. It's registered directly with a prometheus.Registry
+// rather than using the usual package-level counters/gauges, because the
+// set of peers (and so the set of label combinations) changes as the
+// cluster does; a Collector lets us only ever report the peers that
+// currently exist.
+type Metrics struct {
+	hostname                string
+	newWireguardDevicesOnly func() (netlinkshim.Wireguard, error)
+
+	mutex             sync.Mutex
+	prevRxBytes       map[wgtypes.Key]int64
+	prevTxBytes       map[wgtypes.Key]int64
+	prevHandshake     map[wgtypes.Key]time.Time
+	handshakeFailures map[wgtypes.Key]float64
+
+	descBytesRcvd         *prometheus.Desc
+	descBytesSent         *prometheus.Desc
+	descHandshake         *prometheus.Desc
+	descMeta              *prometheus.Desc
+	descPeerConnected     *prometheus.Desc
+	descHandshakeAge      *prometheus.Desc
+	descHandshakeFailures *prometheus.Desc
+}
+
+// NewWireguardMetricsWithShims creates a Metrics collector that opens a new
+// netlinkshim.Wireguard handle (via newWireguardDevicesOnly) on every
+// scrape, rather than holding one open persistently -- this keeps the
+// collector decoupled from the lifetime of whatever handle the rest of the
+// wireguard package is using to manage the device.
+func NewWireguardMetricsWithShims(hostname string, newWireguardDevicesOnly func() (netlinkshim.Wireguard, error)) *Metrics {
+	peerLabels := []string{"hostname", "peer_endpoint", "peer_key", "public_key"}
+	return &Metrics{
+		hostname:                hostname,
+		newWireguardDevicesOnly: newWireguardDevicesOnly,
+		prevRxBytes:             map[wgtypes.Key]int64{},
+		prevTxBytes:             map[wgtypes.Key]int64{},
+		prevHandshake:           map[wgtypes.Key]time.Time{},
+		handshakeFailures:       map[wgtypes.Key]float64{},
+		descBytesRcvd: prometheus.NewDesc(
+			"wireguard_bytes_rcvd",
+			"wireguard interface total incoming bytes to peer",
+			peerLabels, nil,
+		),
+		descBytesSent: prometheus.NewDesc(
+			"wireguard_bytes_sent",
+			"wireguard interface total outgoing bytes to peer",
+			peerLabels, nil,
+		),
+		descHandshake: prometheus.NewDesc(
+			"wireguard_latest_handshake_seconds",
+			"wireguard interface latest handshake unix timestamp in seconds to a peer",
+			peerLabels, nil,
+		),
+		descMeta: prometheus.NewDesc(
+			"wireguard_meta",
+			"wireguard interface and runtime metadata",
+			[]string{"hostname", "iface", "listen_port", "public_key"}, nil,
+		),
+		descPeerConnected: prometheus.NewDesc(
+			"wireguard_peer_connected",
+			"1 if the peer has handshaken within its keepalive-based staleness window, else 0",
+			peerLabels, nil,
+		),
+		descHandshakeAge: prometheus.NewDesc(
+			"wireguard_handshake_age_seconds",
+			"seconds since the peer's last wireguard handshake",
+			peerLabels, nil,
+		),
+		descHandshakeFailures: prometheus.NewDesc(
+			"wireguard_handshake_failures_total",
+			"cumulative count of scrapes at which the peer's handshake was found stalled past its staleness window",
+			peerLabels, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.descBytesRcvd
+	ch <- m.descBytesSent
+	ch <- m.descHandshake
+	ch <- m.descMeta
+	ch <- m.descPeerConnected
+	ch <- m.descHandshakeAge
+	ch <- m.descHandshakeFailures
+}
+
+// Collect implements prometheus.Collector. It opens the WireGuard device,
+// reports its metadata, and reports each peer's handshake time along with
+// the bytes sent/received *since the previous scrape* -- the kernel's
+// counters are lifetime totals for the device, which would make a restart
+// of the scraping process (or simply never having scraped a long-lived
+// peer before) report a confusing step-change rather than a rate Prometheus
+// can reason about.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	wg, err := m.newWireguardDevicesOnly()
+	if err != nil {
+		log.WithError(err).Warn("Failed to open WireGuard device for metrics collection")
+		return
+	}
+	defer wg.Close()
+
+	devices, err := wg.Devices()
+	if err != nil {
+		log.WithError(err).Warn("Failed to list WireGuard devices for metrics collection")
+		return
+	}
+
+	for _, dev := range devices {
+		publicKey := dev.PublicKey.String()
+		ch <- prometheus.MustNewConstMetric(m.descMeta, prometheus.GaugeValue, 1,
+			m.hostname, dev.Name, strconv.Itoa(dev.ListenPort), publicKey)
+
+		for _, peer := range dev.Peers {
+			endpoint := ""
+			if peer.Endpoint != nil {
+				endpoint = peer.Endpoint.String()
+			}
+			peerKey := peer.PublicKey
+
+			rxDelta := peer.ReceiveBytes - m.prevRxBytes[peerKey]
+			txDelta := peer.TransmitBytes - m.prevTxBytes[peerKey]
+			m.prevRxBytes[peerKey] = peer.ReceiveBytes
+			m.prevTxBytes[peerKey] = peer.TransmitBytes
+
+			ch <- prometheus.MustNewConstMetric(m.descBytesRcvd, prometheus.CounterValue, float64(rxDelta),
+				m.hostname, endpoint, peerKey.String(), publicKey)
+			ch <- prometheus.MustNewConstMetric(m.descBytesSent, prometheus.CounterValue, float64(txDelta),
+				m.hostname, endpoint, peerKey.String(), publicKey)
+			ch <- prometheus.MustNewConstMetric(m.descHandshake, prometheus.GaugeValue, float64(peer.LastHandshakeTime.Unix()),
+				m.hostname, endpoint, peerKey.String(), publicKey)
+
+			connected, _ := m.peerConnected(peer)
+			m.recordHandshakeOutcome(peerKey, peer, connected)
+
+			ch <- prometheus.MustNewConstMetric(m.descPeerConnected, prometheus.GaugeValue, boolToFloat(connected),
+				m.hostname, endpoint, peerKey.String(), publicKey)
+			ch <- prometheus.MustNewConstMetric(m.descHandshakeAge, prometheus.GaugeValue, time.Since(peer.LastHandshakeTime).Seconds(),
+				m.hostname, endpoint, peerKey.String(), publicKey)
+			ch <- prometheus.MustNewConstMetric(m.descHandshakeFailures, prometheus.CounterValue, m.handshakeFailures[peerKey],
+				m.hostname, endpoint, peerKey.String(), publicKey)
+		}
+	}
+}
+
+// peerConnected reports whether peer should be considered connected, based on its handshake age
+// against staleHandshakeMultiplier times its configured keepalive interval (falling back to
+// defaultKeepaliveInterval when the peer has no PersistentKeepaliveInterval configured), along
+// with a human-readable reason to use as a status endpoint's disconnectReason.
+func (m *Metrics) peerConnected(peer wgtypes.Peer) (connected bool, reason string) {
+	if peer.LastHandshakeTime.IsZero() {
+		return false, "no handshake received yet"
+	}
+	keepalive := peer.PersistentKeepaliveInterval
+	if keepalive <= 0 {
+		keepalive = defaultKeepaliveInterval
+	}
+	staleAfter := keepalive * staleHandshakeMultiplier
+	age := time.Since(peer.LastHandshakeTime)
+	if age >= staleAfter {
+		return false, fmt.Sprintf("handshake stale: last seen %s ago, threshold %s", age.Round(time.Second), staleAfter)
+	}
+	return true, ""
+}
+
+// recordHandshakeOutcome increments peerKey's cumulative handshake-failure counter on every
+// scrape at which the peer is disconnected and its LastHandshakeTime hasn't moved since the
+// previous scrape. The first scrape that sees a given stalled handshake doesn't count it; each
+// later scrape that still sees it counts it again.
+func (m *Metrics) recordHandshakeOutcome(peerKey wgtypes.Key, peer wgtypes.Peer, connected bool) {
+	if !connected {
+		if prev, ok := m.prevHandshake[peerKey]; ok && prev.Equal(peer.LastHandshakeTime) {
+			m.handshakeFailures[peerKey]++
+		}
+	}
+	m.prevHandshake[peerKey] = peer.LastHandshakeTime
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// wireguardPeerStatus is the JSON representation of a single peer's connection health, served by
+// Metrics.ServeHTTP.
+type wireguardPeerStatus struct {
+	PublicKey        string    `json:"publicKey"`
+	Endpoint         string    `json:"endpoint"`
+	Connected        bool      `json:"connected"`
+	LastHandshake    time.Time `json:"lastHandshake"`
+	RxBytes          int64     `json:"rxBytes"`
+	TxBytes          int64     `json:"txBytes"`
+	DisconnectReason string    `json:"disconnectReason,omitempty"`
+}
+
+// ServeHTTP implements the /status/wireguard debug endpoint: a JSON array of every configured
+// peer's connection health, read straight from the kernel's WireGuard device, so operators can
+// debug tunnels without shelling into the node. Register it with e.g.
+// mux.Handle("/status/wireguard", metrics).
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	wg, err := m.newWireguardDevicesOnly()
+	if err != nil {
+		log.WithError(err).Warn("Failed to open WireGuard device for status endpoint")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer wg.Close()
+
+	devices, err := wg.Devices()
+	if err != nil {
+		log.WithError(err).Warn("Failed to list WireGuard devices for status endpoint")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	statuses := []wireguardPeerStatus{}
+	for _, dev := range devices {
+		for _, peer := range dev.Peers {
+			endpoint := ""
+			if peer.Endpoint != nil {
+				endpoint = peer.Endpoint.String()
+			}
+			connected, reason := m.peerConnected(peer)
+			statuses = append(statuses, wireguardPeerStatus{
+				PublicKey:        peer.PublicKey.String(),
+				Endpoint:         endpoint,
+				Connected:        connected,
+				LastHandshake:    peer.LastHandshakeTime,
+				RxBytes:          peer.ReceiveBytes,
+				TxBytes:          peer.TransmitBytes,
+				DisconnectReason: reason,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(statuses); err != nil {
+		log.WithError(err).Warn("Failed to encode WireGuard status response")
+	}
+}
@@ -2,11 +2,15 @@ package wireguard_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"text/template"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 
 	. "github.com/onsi/ginkgo"
@@ -184,7 +188,63 @@ wireguard_meta{hostname="{{.hostname}}",iface="{{.iface}}",listen_port="{{.liste
 		Expect(buf.String()).To(Equal(buf2.String()))
 	})
 
+	It("should report connection health and serve /status/wireguard JSON", func() {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(wgStats)
+
+		By("reporting a freshly-handshaken peer as connected")
+		wgClient.generatePeerTraffic(1, 1)
+		mfs, err := registry.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(findMetric(mfs, "wireguard_peer_connected").GetGauge().GetValue()).To(Equal(1.0))
+		Expect(findMetric(mfs, "wireguard_handshake_failures_total").GetCounter().GetValue()).To(Equal(0.0))
+
+		By("serving the same peer as connected=true over the status endpoint")
+		rr := httptest.NewRecorder()
+		wgStats.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/status/wireguard", nil))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+
+		var statuses []map[string]interface{}
+		Expect(json.Unmarshal(rr.Body.Bytes(), &statuses)).To(Succeed())
+		Expect(statuses).To(HaveLen(1))
+		Expect(statuses[0]["publicKey"]).To(Equal(mockPeers[1].peer.PublicKey.String()))
+		Expect(statuses[0]["connected"]).To(Equal(true))
+		Expect(statuses[0]["disconnectReason"]).To(BeNil())
+
+		By("reporting a peer with a stale handshake as disconnected, with a reason and failure count")
+		mockPeers[1].peer.LastHandshakeTime = time.Now().Add(-time.Hour)
+		mfs, err = registry.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(findMetric(mfs, "wireguard_peer_connected").GetGauge().GetValue()).To(Equal(0.0))
+
+		// A second scrape without the handshake time moving on is what flags the stall.
+		mfs, err = registry.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(findMetric(mfs, "wireguard_handshake_failures_total").GetCounter().GetValue()).To(Equal(1.0))
+
+		rr = httptest.NewRecorder()
+		wgStats.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/status/wireguard", nil))
+		Expect(json.Unmarshal(rr.Body.Bytes(), &statuses)).To(Succeed())
+		Expect(statuses[0]["connected"]).To(Equal(false))
+		Expect(statuses[0]["disconnectReason"]).ToNot(BeEmpty())
+	})
+
 	AfterEach(func() {
 		wgClient = nil
 	})
 })
+
+// findMetric returns the single metric matching name across the families in mfs, failing the
+// test via Gomega if it isn't found exactly once -- the test only ever deals with one peer, so
+// each family has exactly one metric in it.
+func findMetric(mfs []*dto.MetricFamily, name string) *dto.Metric {
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		ExpectWithOffset(1, mf.Metric).To(HaveLen(1))
+		return mf.Metric[0]
+	}
+	ExpectWithOffset(1, false).To(BeTrue(), "metric family %s not found", name)
+	return nil
+}
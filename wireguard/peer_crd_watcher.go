@@ -0,0 +1,148 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wireguard
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/projectcalico/felix/ip"
+)
+
+// PeerCRDSpec is the subset of a WireguardPeer CRD's spec that's relevant
+// to configuring a tunnel: everything needed to describe a non-Calico
+// endpoint that should still get a WireGuard tunnel, such as an on-prem
+// gateway or a third-party service.
+type PeerCRDSpec struct {
+	Name                string
+	PublicKey           string // base64, as stored in the CRD
+	Endpoint            string
+	AllowedCIDRs        []string
+	PersistentKeepalive time.Duration
+}
+
+// PeerCRDLister returns the current set of WireguardPeer CRDs, keyed by
+// CRD name.  It's a function rather than a full client interface so this
+// package doesn't need to depend on the generated clientset; the
+// datastore-facing code that does can be as simple as wrapping a List call.
+type PeerCRDLister func() (map[string]PeerCRDSpec, error)
+
+// PeerCRDWatcher polls a PeerCRDLister and feeds the resulting peers into
+// a PeerStore, so that a cluster operator can point Felix at peers that
+// aren't Calico Nodes at all -- e.g. a WireGuard-only gateway -- purely by
+// creating a CRD, without Felix needing to know anything about how that
+// CRD is served.
+type PeerCRDWatcher struct {
+	lister       PeerCRDLister
+	store        *PeerStore
+	pollInterval time.Duration
+
+	known map[string]wgtypes.Key // CRD name -> public key, to compute deletes
+}
+
+// defaultPeerCRDPollInterval is the polling period used when
+// NewPeerCRDWatcher is given no interval; the lister has no native watch.
+const defaultPeerCRDPollInterval = 30 * time.Second
+
+// NewPeerCRDWatcher creates a watcher that syncs lister's results into
+// store. Pass interval <= 0 to use defaultPeerCRDPollInterval.
+func NewPeerCRDWatcher(lister PeerCRDLister, store *PeerStore, interval time.Duration) *PeerCRDWatcher {
+	if interval <= 0 {
+		interval = defaultPeerCRDPollInterval
+	}
+	return &PeerCRDWatcher{
+		lister:       lister,
+		store:        store,
+		pollInterval: interval,
+		known:        map[string]wgtypes.Key{},
+	}
+}
+
+// Start runs an initial sync synchronously, then continues polling in the
+// background until stopC is closed.
+func (w *PeerCRDWatcher) Start(stopC <-chan struct{}) {
+	w.syncOnce()
+	go func() {
+		ticker := time.NewTicker(w.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.syncOnce()
+			case <-stopC:
+				return
+			}
+		}
+	}()
+}
+
+func (w *PeerCRDWatcher) syncOnce() {
+	specs, err := w.lister()
+	if err != nil {
+		log.WithError(err).Warn("Failed to list WireguardPeer CRDs; keeping last known-good peers")
+		return
+	}
+
+	seen := make(map[string]bool, len(specs))
+	for name, spec := range specs {
+		seen[name] = true
+		pubKey, err := wgtypes.ParseKey(spec.PublicKey)
+		if err != nil {
+			log.WithError(err).WithField("peer", name).Warn("WireguardPeer CRD has an invalid public key; skipping")
+			continue
+		}
+		cidrs := cidrsFromStrings(name, spec.AllowedCIDRs)
+		if oldKey, ok := w.known[name]; ok && oldKey != pubKey {
+			// Public key rotated under the same CRD name; drop the old peer.
+			w.store.RemoveExternalPeer(oldKey)
+		}
+		w.known[name] = pubKey
+		w.store.UpdateExternalPeer(ExternalPeer{
+			Name:                name,
+			PublicKey:           pubKey,
+			Endpoint:            spec.Endpoint,
+			AllowedCIDRs:        cidrs,
+			PersistentKeepalive: spec.PersistentKeepalive,
+		})
+	}
+
+	for name, pubKey := range w.known {
+		if !seen[name] {
+			w.store.RemoveExternalPeer(pubKey)
+			delete(w.known, name)
+		}
+	}
+}
+
+// cidrsFromStrings parses each of raw as a CIDR, logging and skipping (not
+// failing) any that don't parse, so a single typo in a CRD doesn't take
+// down the whole peer.
+func cidrsFromStrings(peerName string, raw []string) []ip.CIDR {
+	cidrs := make([]ip.CIDR, 0, len(raw))
+	for _, s := range raw {
+		cidr, err := ip.ParseCIDROrIP(s)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"peer": peerName,
+				"cidr": s,
+			}).Warn("WireguardPeer CRD has an invalid allowed CIDR; skipping it")
+			continue
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs
+}
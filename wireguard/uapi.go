@@ -0,0 +1,124 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wireguard
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// uapiConfigString renders a wgtypes.Config as a wireguard-go UAPI
+// "set" configuration string (see wireguard-go's device/uapi.go for the
+// on-the-wire grammar), so the userspace backend can push configuration
+// through IpcSetOperation exactly as it would over a real UAPI socket.
+func uapiConfigString(cfg wgtypes.Config) string {
+	var b strings.Builder
+	if cfg.PrivateKey != nil {
+		fmt.Fprintf(&b, "private_key=%s\n", hex.EncodeToString(cfg.PrivateKey[:]))
+	}
+	if cfg.ListenPort != nil {
+		fmt.Fprintf(&b, "listen_port=%d\n", *cfg.ListenPort)
+	}
+	if cfg.ReplacePeers {
+		b.WriteString("replace_peers=true\n")
+	}
+	for _, p := range cfg.Peers {
+		fmt.Fprintf(&b, "public_key=%s\n", hex.EncodeToString(p.PublicKey[:]))
+		if p.Remove {
+			b.WriteString("remove=true\n")
+			continue
+		}
+		if p.Endpoint != nil {
+			fmt.Fprintf(&b, "endpoint=%s\n", p.Endpoint.String())
+		}
+		if p.PersistentKeepaliveInterval != nil {
+			fmt.Fprintf(&b, "persistent_keepalive_interval=%d\n", int(p.PersistentKeepaliveInterval.Seconds()))
+		}
+		if p.ReplaceAllowedIPs {
+			b.WriteString("replace_allowed_ips=true\n")
+		}
+		for _, ipNet := range p.AllowedIPs {
+			fmt.Fprintf(&b, "allowed_ip=%s\n", ipNet.String())
+		}
+	}
+	return b.String()
+}
+
+// deviceFromUAPI reads a device's state back out via its UAPI "get"
+// operation (getOp writes the same grammar uapiConfigString produces, but
+// with current values rather than desired ones) and parses it into a
+// wgtypes.Device, the same shape wgctrl.Client.Device returns for a
+// kernel device.  Only the private key, the listen port and each peer's
+// public key, last handshake second and rx/tx byte counts are read; other
+// keys are ignored, so fields such as the device public key and peer
+// endpoints stay at their zero values.
+func deviceFromUAPI(name string, getOp func() (string, error)) (*wgtypes.Device, error) {
+	raw, err := getOp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read userspace wireguard device state: %w", err)
+	}
+	dev := &wgtypes.Device{Name: name, Type: wgtypes.LinuxKernel}
+	var curPeer *wgtypes.Peer
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, val := kv[0], kv[1]
+		switch key {
+		case "private_key":
+			keyBytes, err := hex.DecodeString(val)
+			if err == nil && len(keyBytes) == len(dev.PrivateKey) {
+				copy(dev.PrivateKey[:], keyBytes)
+			}
+		case "listen_port":
+			if port, err := strconv.Atoi(val); err == nil {
+				dev.ListenPort = port
+			}
+		case "public_key":
+			dev.Peers = append(dev.Peers, wgtypes.Peer{})
+			curPeer = &dev.Peers[len(dev.Peers)-1]
+			keyBytes, err := hex.DecodeString(val)
+			if err == nil && len(keyBytes) == len(curPeer.PublicKey) {
+				copy(curPeer.PublicKey[:], keyBytes)
+			}
+		case "last_handshake_time_sec":
+			if curPeer != nil {
+				sec, _ := strconv.ParseInt(val, 10, 64)
+				curPeer.LastHandshakeTime = time.Unix(sec, curPeer.LastHandshakeTime.UnixNano()%int64(time.Second))
+			}
+		case "rx_bytes":
+			if curPeer != nil {
+				n, _ := strconv.ParseInt(val, 10, 64)
+				curPeer.ReceiveBytes = n
+			}
+		case "tx_bytes":
+			if curPeer != nil {
+				n, _ := strconv.ParseInt(val, 10, 64)
+				curPeer.TransmitBytes = n
+			}
+		}
+	}
+	return dev, nil
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2022 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wireguard
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/projectcalico/felix/netlinkshim"
+)
+
+// BackendKind selects which WireGuard implementation Felix drives.
+type BackendKind string
+
+const (
+	// BackendKernel uses the in-kernel WireGuard module via netlink, the
+	// normal case on any reasonably modern Linux.
+	BackendKernel BackendKind = "kernel"
+	// BackendUserspace runs wireguard-go, a userspace WireGuard
+	// implementation, backed by a tun device (BoringTun is not
+	// supported), for kernels/distros that lack the
+	// WireGuard module (e.g. older kernels without backports, or
+	// sandboxed environments where loading a kernel module isn't an
+	// option).
+	BackendUserspace BackendKind = "userspace"
+)
+
+// userspaceDevice adapts a wireguard-go *device.Device plus its tun.Device
+// to the netlinkshim.Wireguard interface, so the rest of the wireguard
+// package can drive either backend identically.
+type userspaceDevice struct {
+	name   string
+	tunDev tun.Device
+	dev    *device.Device
+}
+
+// NewUserspaceDevice creates (or takes over) a tun device named name and
+// starts a wireguard-go device bound to it.  Configuration is pushed later
+// through ConfigureDevice, which translates a wgtypes.Config into the UAPI
+// format wireguard-go's IpcSet expects, so callers don't need to know about
+// UAPI at all.
+func NewUserspaceDevice(name string, logger device.Logger) (netlinkshim.Wireguard, error) {
+	tunDev, err := tun.CreateTUN(name, device.DefaultMTU)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tun device %s for userspace wireguard: %w", name, err)
+	}
+	dev := device.NewDevice(tunDev, conn.NewDefaultBind(), logger)
+	if err := dev.Up(); err != nil {
+		tunDev.Close()
+		return nil, fmt.Errorf("failed to bring up userspace wireguard device %s: %w", name, err)
+	}
+	return &userspaceDevice{name: name, tunDev: tunDev, dev: dev}, nil
+}
+
+func (u *userspaceDevice) Close() error {
+	u.dev.Close()
+	return u.tunDev.Close()
+}
+
+// DeviceByName only supports looking up its own device; the userspace
+// backend only ever manages the single device it was created for.
+func (u *userspaceDevice) DeviceByName(name string) (*wgtypes.Device, error) {
+	if name != u.name {
+		return nil, fmt.Errorf("userspace wireguard backend only manages device %s, not %s", u.name, name)
+	}
+	return deviceFromUAPI(u.name, func() (string, error) {
+		var b strings.Builder
+		if err := u.dev.IpcGetOperation(&b); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	})
+}
+
+func (u *userspaceDevice) Devices() ([]*wgtypes.Device, error) {
+	dev, err := u.DeviceByName(u.name)
+	if err != nil {
+		return nil, err
+	}
+	return []*wgtypes.Device{dev}, nil
+}
+
+func (u *userspaceDevice) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	if name != u.name {
+		return fmt.Errorf("userspace wireguard backend only manages device %s, not %s", u.name, name)
+	}
+	return u.dev.IpcSetOperation(strings.NewReader(uapiConfigString(cfg)))
+}